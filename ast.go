@@ -0,0 +1,181 @@
+package globingo
+
+// The kind of a Node of a parsed pattern
+type NodeKind int
+
+const (
+	// Plain text, including escaped characters
+	TextNode NodeKind = iota
+	// A Windows volume, like C: or \\server\share
+	VolumeNode
+	// ?
+	AnyCharNode
+	// [abc] or [^a-z]
+	CharSetNode
+	// *
+	StarNode
+	// **
+	GlobStarNode
+	// {a,b}, or a named wildcard, {name:a}
+	AlternationNode
+	// {1..20}
+	NumericRangeNode
+	// ?(a|b), *(a|b), +(a|b), @(a|b) or !(a|b)
+	ExtGlobNode
+)
+
+var nodeKindNames = []string{
+	TextNode:         "Text",
+	VolumeNode:       "Volume",
+	AnyCharNode:      "AnyChar",
+	CharSetNode:      "CharSet",
+	StarNode:         "Star",
+	GlobStarNode:     "GlobStar",
+	AlternationNode:  "Alternation",
+	NumericRangeNode: "NumericRange",
+	ExtGlobNode:      "ExtGlob",
+}
+
+func (self NodeKind) String() string {
+	if self < 0 || int(self) >= len(nodeKindNames) {
+		return "Unknown"
+	}
+	return nodeKindNames[self]
+}
+
+// An inclusive range of runes in a CharSetNode. A single member has
+// From == To.
+type CharRange struct {
+	From rune
+	To   rune
+}
+
+// A node of a parsed pattern. Nodes are copies, so changing one doesn't
+// change the Glob it came from. Only the fields for the node's kind are set.
+type Node struct {
+	Kind NodeKind
+	// The node's text is pattern[Start:End], in bytes
+	Start int
+	End   int
+	// The number of a top-level wildcard, as used by Match.GetWildcardText,
+	// starting at 1. It is 0 for other nodes.
+	Wildcard int
+
+	// For a TextNode, the text to match, without escapes. For a
+	// VolumeNode, the volume in canonical form, like C: or \\SERVER\SHARE.
+	Text string
+
+	// For a CharSetNode
+	Inverted bool
+	Ranges   []CharRange
+	// The names of classes, like alpha or Greek
+	Classes []string
+
+	// For a GlobStarNode, whether it is followed by a separator, so that
+	// it only matches directories
+	DirectoriesOnly bool
+
+	// For a NumericRangeNode. When Width is not 0, the number must have
+	// that many digits.
+	From  int64
+	To    int64
+	Width int
+
+	// For an ExtGlobNode: '?', '*', '+', '@' or '!'
+	Operator rune
+
+	// For an AlternationNode which is a named wildcard, like {year:*}, the
+	// name
+	Name string
+
+	// For an AlternationNode or an ExtGlobNode, the nodes of each of the
+	// alternatives
+	Alternatives [][]Node
+}
+
+// Parses the pattern as NewWithOptions does, and returns its top-level
+// nodes, without compiling a matcher.
+func Parse(pattern string, opts ...Option) ([]Node, error) {
+	glob, err := parsePattern(pattern, opts)
+	if err != nil {
+		return nil, err
+	}
+	return glob.Tokens(), nil
+}
+
+// Returns the top-level nodes of the Glob's pattern.
+func (self *Glob) Tokens() []Node {
+	var nodes []Node
+	if self.volumeLength > 0 {
+		nodes = append(nodes, Node{
+			Kind: VolumeNode,
+			End:  self.volumeLength,
+			Text: self.volume,
+		})
+	}
+
+	wildcard := 0
+	for _, token := range self.tokens {
+		node := tokenNode(token)
+		if token.IsWildcard() {
+			wildcard++
+			node.Wildcard = wildcard
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func tokenNode(token tokenInterface) Node {
+	node := Node{
+		Start: token.span().start,
+		End:   token.span().end,
+	}
+
+	switch t := token.(type) {
+	case *tokenPlainText:
+		node.Kind = TextNode
+		node.Text = t.text
+	case *tokenSingleChar:
+		node.Kind = AnyCharNode
+	case *tokenCharSet:
+		node.Kind = CharSetNode
+		node.Inverted = t.inverted
+		for _, charRange := range t.ranges {
+			node.Ranges = append(node.Ranges, CharRange{From: charRange.from, To: charRange.to})
+		}
+		for _, class := range t.classes {
+			node.Classes = append(node.Classes, class.name)
+		}
+	case *tokenMultiCharSingleDirectory:
+		node.Kind = StarNode
+	case *tokenMultiCharMultiDirectory:
+		node.Kind = GlobStarNode
+		node.DirectoriesOnly = t.directoriesOnly
+	case *tokenAlternation:
+		node.Kind = AlternationNode
+		node.Name = t.name
+		node.Alternatives = alternativesNodes(t.alternatives)
+	case *tokenNumericRange:
+		node.Kind = NumericRangeNode
+		node.From = t.from
+		node.To = t.to
+		node.Width = t.width
+	case *tokenExtGlob:
+		node.Kind = ExtGlobNode
+		node.Operator = t.operator
+		node.Alternatives = alternativesNodes(t.alternatives)
+	}
+	return node
+}
+
+func alternativesNodes(alternatives [][]tokenInterface) [][]Node {
+	nodes := make([][]Node, len(alternatives))
+	for i, tokens := range alternatives {
+		nodes[i] = make([]Node, len(tokens))
+		for j, token := range tokens {
+			nodes[i][j] = tokenNode(token)
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,63 @@
+package globingo
+
+import (
+	"unicode"
+)
+
+// A named class inside of a bracket expression, like [:alpha:] or [:Greek:]
+type charClass struct {
+	name   string
+	tables []*unicode.RangeTable
+}
+
+// Hexadecimal digits, for [[:xdigit:]]
+var xdigitTable = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: '0', Hi: '9', Stride: 1},
+		{Lo: 'A', Hi: 'F', Stride: 1},
+		{Lo: 'a', Hi: 'f', Stride: 1},
+	},
+	LatinOffset: 3,
+}
+
+// Tab, which [[:blank:]] has in addition to the space separators
+var tabTable = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: '\t', Hi: '\t', Stride: 1},
+	},
+	LatinOffset: 1,
+}
+
+// The POSIX character classes. These use the Unicode definitions,
+// so [[:alpha:]] matches any letter, not just ASCII letters.
+var posixClasses = map[string][]*unicode.RangeTable{
+	"alnum":  {unicode.Letter, unicode.Digit},
+	"alpha":  {unicode.Letter},
+	"blank":  {tabTable, unicode.Zs},
+	"cntrl":  {unicode.Cc},
+	"digit":  {unicode.Digit},
+	"graph":  {unicode.L, unicode.M, unicode.N, unicode.P, unicode.S},
+	"lower":  {unicode.Lower},
+	"print":  {unicode.L, unicode.M, unicode.N, unicode.P, unicode.S, unicode.Zs},
+	"punct":  {unicode.P, unicode.S},
+	"space":  {unicode.White_Space},
+	"upper":  {unicode.Upper},
+	"word":   {unicode.Letter, unicode.Digit, unicode.Pc},
+	"xdigit": {xdigitTable},
+}
+
+// Returns the tables for a named character class, as in [[:name:]].
+// The name is a POSIX class, a Unicode category like "L" or "Lu",
+// or a Unicode script like "Greek".
+func lookupCharClass(name string) ([]*unicode.RangeTable, bool) {
+	if tables, ok := posixClasses[name]; ok {
+		return tables, true
+	}
+	if table, ok := unicode.Categories[name]; ok {
+		return []*unicode.RangeTable{table}, true
+	}
+	if table, ok := unicode.Scripts[name]; ok {
+		return []*unicode.RangeTable{table}, true
+	}
+	return nil, false
+}
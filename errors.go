@@ -0,0 +1,135 @@
+package globingo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The kind of a SyntaxError
+type SyntaxErrorKind int
+
+const (
+	// A '[' without a closing ']'
+	UnterminatedBracket SyntaxErrorKind = iota
+	// A '[:' without a closing ':]'
+	UnterminatedClass
+	// A class name, like [:bogus:], which isn't known
+	UnknownClass
+	// A byte which isn't valid UTF-8, inside of a set
+	InvalidUTF8
+	// A range whose start and end are the same, like [a-a]
+	EmptyRange
+	// A range whose start is greater than its end, like [z-a]
+	InvertedRange
+	// A '{' without a closing '}'
+	UnterminatedBrace
+	// An extended glob '(' without a closing ')'
+	UnterminatedParenthesis
+	// A '\' at the end of the pattern
+	TrailingBackslash
+	// A '**' in a pattern which isn't recursive
+	DisallowedGlobStar
+	// A wildcard name, like {year:*}, which another wildcard already has
+	DuplicateName
+	// A wildcard name inside of braces or an extended glob, which aren't
+	// wildcards of their own
+	NestedName
+)
+
+var syntaxErrorKindNames = []string{
+	UnterminatedBracket:     "UnterminatedBracket",
+	UnterminatedClass:       "UnterminatedClass",
+	UnknownClass:            "UnknownClass",
+	InvalidUTF8:             "InvalidUTF8",
+	EmptyRange:              "EmptyRange",
+	InvertedRange:           "InvertedRange",
+	UnterminatedBrace:       "UnterminatedBrace",
+	UnterminatedParenthesis: "UnterminatedParenthesis",
+	TrailingBackslash:       "TrailingBackslash",
+	DisallowedGlobStar:      "DisallowedGlobStar",
+	DuplicateName:           "DuplicateName",
+	NestedName:              "NestedName",
+}
+
+func (self SyntaxErrorKind) String() string {
+	if self < 0 || int(self) >= len(syntaxErrorKindNames) {
+		return "Unknown"
+	}
+	return syntaxErrorKindNames[self]
+}
+
+// A SyntaxError is returned by New, NewWithOptions and Parse when a
+// pattern can't be parsed. Use errors.As to get it.
+type SyntaxError struct {
+	Kind    SyntaxErrorKind
+	Pattern string
+	// Where the error is in the pattern, counted in bytes and in runes,
+	// starting at 0
+	Offset     int
+	RuneOffset int
+	// The description of the error, which Error returns
+	Message string
+}
+
+func newSyntaxError(kind SyntaxErrorKind, pattern string, offset int, message string) *SyntaxError {
+	return &SyntaxError{
+		Kind:       kind,
+		Pattern:    pattern,
+		Offset:     offset,
+		RuneOffset: utf8.RuneCountInString(pattern[:offset]),
+		Message:    message,
+	}
+}
+
+func (self *SyntaxError) Error() string {
+	return self.Message
+}
+
+// Returns the message, followed by the pattern, and a caret under the
+// rune where the error is:
+//
+//	Opening bracket at position 5 not terminated
+//	src/[abc
+//	    ^
+func (self *SyntaxError) Annotated() string {
+	return fmt.Sprintf("%s\n%s\n%s^", self.Message, self.Pattern, strings.Repeat(" ", self.RuneOffset))
+}
+
+// SyntaxErrors is returned instead of a *SyntaxError when the AllErrors
+// option is given. Like the error from errors.Join, it unwraps to each
+// error, so errors.As finds the first *SyntaxError.
+type SyntaxErrors []*SyntaxError
+
+// Returns the messages, one per line
+func (self SyntaxErrors) Error() string {
+	messages := make([]string, len(self))
+	for i, err := range self {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (self SyntaxErrors) Unwrap() []error {
+	errs := make([]error, len(self))
+	for i, err := range self {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Sorts the errors by offset, and drops those which repeat an error
+func (self SyntaxErrors) sorted() SyntaxErrors {
+	sort.SliceStable(self, func(i, j int) bool {
+		return self[i].Offset < self[j].Offset
+	})
+	var result SyntaxErrors
+	for _, err := range self {
+		if n := len(result); n > 0 && result[n-1].Offset == err.Offset && result[n-1].Kind == err.Kind {
+			continue
+		}
+		result = append(result, err)
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package globingo
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Builds a string which the glob matches, by replacing the Nth wildcard
+// with the Nth value. This is the reverse of Match. Each value must be one
+// that its wildcard can match where it is, so a value for ? must be one
+// character, a value for [a-z] must be in the set, and a value for * can't
+// contain a separator. The text between wildcards is copied from the
+// pattern, without escapes.
+func (self *Glob) Expand(values ...string) (string, error) {
+	if len(values) != len(self.wildcardPositions) {
+		return "", errors.Errorf("This Glob has %d wildcards, but %d values were given",
+			len(self.wildcardPositions), len(values))
+	}
+	return self.expand(func(n int) (string, bool) {
+		return values[n-1], true
+	})
+}
+
+// Like Expand, but the values are given by the name of their wildcard,
+// like "year" for {year:*}, or by its number, like "1". Every wildcard
+// needs a value, and every key must name a wildcard.
+func (self *Glob) ExpandNamed(values map[string]string) (string, error) {
+	byNumber := make(map[int]string, len(values))
+	for key, value := range values {
+		n, ok := self.wildcardNames[key]
+		if !ok {
+			var err error
+			n, err = strconv.Atoi(key)
+			if err != nil || n < 1 || n > len(self.wildcardPositions) {
+				return "", errors.Errorf("This Glob has no wildcard named %q", key)
+			}
+		}
+		if _, ok := byNumber[n]; ok {
+			return "", errors.Errorf("Wildcard #%d was given more than one value", n)
+		}
+		byNumber[n] = value
+	}
+	return self.expand(func(n int) (string, bool) {
+		value, ok := byNumber[n]
+		return value, ok
+	})
+}
+
+// Walks the tokens, getting the Nth wildcard's value from valueOf
+func (self *Glob) expand(valueOf func(n int) (string, bool)) (string, error) {
+	type expandedWildcard struct {
+		token tokenInterface
+		value string
+		// The value's position in the normalized string
+		start int
+		end   int
+	}
+
+	// Build the whole string first, so that each value is checked with the
+	// text on both sides of it, like the separator which follows **/
+	var result, normalized strings.Builder
+	add := func(text string) {
+		result.WriteString(text)
+		normalized.WriteString(newNormalizedText(text, self.normalization).normalized)
+	}
+
+	add(self.pattern[:self.volumeLength])
+	var wildcards []expandedWildcard
+	for _, token := range self.tokens {
+		if !token.IsWildcard() {
+			add(token.(*tokenPlainText).text)
+			continue
+		}
+
+		value, ok := valueOf(len(wildcards) + 1)
+		if !ok {
+			return "", errors.Errorf("No value was given for wildcard #%d, %s", len(wildcards)+1, token.String())
+		}
+		start := normalized.Len()
+		add(value)
+		wildcards = append(wildcards, expandedWildcard{token: token, value: value, start: start, end: normalized.Len()})
+	}
+
+	// Each wildcard must match the whole of its value
+	haystack := normalized.String()
+	for i, wildcard := range wildcards {
+		program := compileTokens([]tokenInterface{wildcard.token}, self.separators)
+		if _, matched := program.run(haystack, wildcard.start, wildcard.end, true); !matched {
+			return "", errors.Errorf("The value %q for wildcard #%d doesn't match %s",
+				wildcard.value, i+1, wildcard.token.String())
+		}
+	}
+	return result.String(), nil
+}
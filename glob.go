@@ -3,12 +3,10 @@
 package globingo
 
 import (
-	"context"
-	"fmt"
-	//        "log"
 	"strings"
+	"unicode/utf8"
 
-	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
 )
 
 // Are we dealing with Unix-style or Windows-style paths? This affects
@@ -27,15 +25,28 @@ const (
 	kUnixStyle    = '/'
 )
 
+// A Glob is a compiled glob pattern. A Glob is safe for concurrent use;
+// each match runs the compiled program without goroutines.
 type Glob struct {
-	pattern                     string
-	directorySeparator          rune
-	recursiveAllowed            bool
-	tokens                      []tokenInterface
-	hasTokenWithMultipleAnswers bool
+	pattern          string
+	separators       separatorSet
+	recursiveAllowed bool
+	tokens           []tokenInterface
+	program          *program
+	// The last token on its own, to find its longest match
+	lastToken *program
+	// nil means not to normalize
+	normalization *norm.Form
+	// Whether paths can start with a Windows volume, and the pattern's
+	// volume, in canonical form
+	windowsVolumes bool
+	volume         string
+	volumeLength   int
 
 	// Maps Nth wildcard to Mth token (well, N-1, since the slice is 0-indexed)
 	wildcardPositions []int
+	// Maps the name of a wildcard, like {year:*}, to N
+	wildcardNames map[string]int
 }
 
 // Return a new Glob object. The pattern is the glob pattern to use.
@@ -43,51 +54,113 @@ type Glob struct {
 // NativeStyle, WindowsStyle, or UnixStyle.
 // When recursive is true, '**' is allowed as a wildcard which matches any directory
 // or filename, to any depth. When false, '**' is not allowed in a glob pattern.
+// Any options, like ExtGlob, are applied in order, after the style and recursive
+// arguments; New is the same as NewWithOptions with Style(style) and
+// Recursive(recursive) given first.
 // An error is returned when the glob pattern contains a syntax error.
-func New(pattern string, style PathStyle, recursive bool) (*Glob, error) {
-	if !recursive && strings.Contains(pattern, "**") {
-		return nil, errors.Errorf("Non-recursive glob pattern '%s' cannot contain '**'", pattern)
-	}
-
-	var directorySeparator rune
-	switch style {
-	case NativeStyle:
-		directorySeparator = kNativeDirectorySeparator
-	case UnixStyle:
-		directorySeparator = '/'
-	case WindowsStyle:
-		directorySeparator = '\\'
-	default:
-		panic(fmt.Sprintf("Unexpected style %q", style))
+func New(pattern string, style PathStyle, recursive bool, opts ...Option) (*Glob, error) {
+	return NewWithOptions(pattern, append([]Option{Style(style), Recursive(recursive)}, opts...)...)
+}
+
+// Return a new Glob object. The pattern is the glob pattern to use.
+// The options are applied in order, starting from the defaults: NativeStyle,
+// not recursive, and the defaults that each option describes.
+// An error is returned when the glob pattern contains a syntax error.
+func NewWithOptions(pattern string, opts ...Option) (*Glob, error) {
+	glob, err := parsePattern(pattern, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	glob.program = compileTokens(glob.tokens, glob.separators)
+	if len(glob.tokens) > 0 {
+		glob.lastToken = compileTokens(glob.tokens[len(glob.tokens)-1:], glob.separators)
+	}
+	return glob, nil
+}
+
+// Parses the pattern into a Glob which has no program
+func parsePattern(pattern string, opts []Option) (*Glob, error) {
+	o := newOptions(opts)
+
+	separators := o.separators
+	if separators == nil {
+		var err error
+		separators, err = styleSeparators(o.style)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The volume is compared on its own, so that wildcards can't match it
+	windowsVolumes := isWindowsStyle(o.style) && o.separators == nil
+	var volume string
+	var volumeLength int
+	if windowsVolumes {
+		volume, volumeLength = splitWindowsVolume(pattern)
 	}
 
-	tokens, err := tokenizePattern(pattern, directorySeparator)
+	tokens, err := tokenizePatternAt(pattern, volumeLength, separators, opts...)
+	if syntaxErrors, ok := err.(SyntaxErrors); ok {
+		err = syntaxErrors.sorted()
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	var wildcardPositions []int
+	wildcardNames := make(map[string]int)
 
-	hasTokenWithMultipleAnswers := false
 	for tokenIndex, token := range tokens {
 		if token.IsWildcard() {
 			wildcardPositions = append(wildcardPositions, tokenIndex)
 		}
-		if token.CanHaveMultipleAnswers() {
-			hasTokenWithMultipleAnswers = true
+		if alternation, ok := token.(*tokenAlternation); ok && alternation.name != "" {
+			wildcardNames[alternation.name] = len(wildcardPositions)
 		}
 	}
 
 	return &Glob{
-		pattern:                     pattern,
-		directorySeparator:          directorySeparator,
-		recursiveAllowed:            recursive,
-		hasTokenWithMultipleAnswers: hasTokenWithMultipleAnswers,
+		pattern:           pattern,
+		separators:        separators,
+		recursiveAllowed:  o.recursive,
 		tokens:            tokens,
+		normalization:     o.normalization,
+		windowsVolumes:    windowsVolumes,
+		volume:            volume,
+		volumeLength:      volumeLength,
 		wildcardPositions: wildcardPositions,
+		wildcardNames:     wildcardNames,
 	}, nil
 }
 
+// Returns a pattern which matches the literal text s, by escaping each
+// special character. If '\\' is not one of the style's directory separators,
+// backslash escapes are used, like \*. Otherwise bracket escapes are used,
+// like [*]. The pattern is meant for a Glob which uses the default escape
+// setting for the style.
+func QuoteMeta(s string, style PathStyle) string {
+	separators, err := styleSeparators(style)
+	// Bracket escapes work with any style
+	backslashEscapes := err == nil && !separators.contains('\\')
+
+	var quoted strings.Builder
+	for pos := 0; pos < len(s); {
+		r, w := utf8.DecodeRuneInString(s[pos:])
+		text := s[pos : pos+w]
+		pos += w
+
+		if backslashEscapes && (isAnyWildcard(r) || r == '\\') {
+			quoted.WriteString("\\" + text)
+		} else if !backslashEscapes && isAnyWildcard(r) {
+			quoted.WriteString("[" + text + "]")
+		} else {
+			quoted.WriteString(text)
+		}
+	}
+	return quoted.String()
+}
+
 // Returns the number of wildcard patterns. Useful for Match.GetWildcardText()
 func (self *Glob) NumWildcards() int {
 	return len(self.wildcardPositions)
@@ -104,113 +177,173 @@ func (self *Glob) StartsWith(haystack string) *Match {
 	return self.match(haystack, false)
 }
 
-func (self *Glob) match(haystack string, matchCompleteString bool) *Match {
-	var m *Match
+// Match the glob against the end of the string given as 'haystack'. The
+// match can start anywhere; the leftmost start wins, so it is the longest
+// match that the glob allows.
+func (self *Glob) EndsWith(haystack string) *Match {
+	text := newNormalizedText(haystack, self.normalization)
+	return self.find(haystack, &text, 0, false, true)
+}
 
-	if self.hasTokenWithMultipleAnswers {
-		subMatch := self._matchRecursive(0, 0, haystack, matchCompleteString)
-		if subMatch != nil {
-			m = subMatch.combineIntoMatch()
-		} else {
-			return nil
-		}
-	} else {
-		m = self._matchNoRecursive(haystack, matchCompleteString)
+// Finds the leftmost match of the glob anywhere in the haystack, or returns
+// nil. Where matches start at the same position, the length is chosen as by
+// StartsWith. The Match's Start and End give its position. Since the match
+// starts as far left as it can, a leading * takes in any text before it
+// back to a separator, and a leading ** takes in everything before it.
+func (self *Glob) Find(haystack string) *Match {
+	text := newNormalizedText(haystack, self.normalization)
+	return self.find(haystack, &text, 0, false, false)
+}
+
+// Returns the start and end of the leftmost match in the haystack, as
+// Find does, or nil.
+func (self *Glob) FindIndex(haystack string) []int {
+	match := self.Find(haystack)
+	if match == nil {
+		return nil
 	}
+	return []int{match.Start(), match.End()}
+}
 
-	if m != nil {
-		if matchCompleteString {
-			// any leftover characters that didn't match?
-			if m.lastPosition != len(haystack) {
-				return nil
-			}
+// Finds the successive, non-overlapping matches of the glob in the
+// haystack, each of them found as by Find. An empty match right after
+// another match is ignored. If n >= 0, it returns at most n matches.
+func (self *Glob) FindAll(haystack string, n int) []*Match {
+	text := newNormalizedText(haystack, self.normalization)
+	var matches []*Match
+	previousEnd := -1
+	for from := 0; from <= len(text.normalized) && (n < 0 || len(matches) < n); {
+		caps, start, end, ok := self.run(&text, from, false, false)
+		if !ok {
+			break
+		}
+		if end > start || start != previousEnd {
+			matches = append(matches, self.newMatch(haystack, &text, caps, start, end))
+			previousEnd = end
+		}
+		if end > start {
+			from = end
+		} else if start < len(text.normalized) {
+			_, w := utf8.DecodeRuneInString(text.normalized[start:])
+			from = start + w
+		} else {
+			break
 		}
-		m.wildcardPositions = self.wildcardPositions
 	}
-	return m
+	return matches
 }
 
-func (self *Glob) _matchRecursive(tokenIndex int, pos int, haystack string, matchCompleteString bool) *subMatch {
-	token := self.tokens[tokenIndex]
-	//        log.Printf("_matchRecursive %s tokenIndex=%d pos=%d haystack=%s", token.String(), tokenIndex, pos, haystack)
+// Matches the glob at the start of the haystack, for Match and StartsWith.
+// When matchCompleteString is set, the match must also reach its end.
+func (self *Glob) match(haystack string, matchCompleteString bool) *Match {
+	text := newNormalizedText(haystack, self.normalization)
+	return self.find(haystack, &text, 0, true, matchCompleteString)
+}
 
-	// Is this the last token? There's no more multiple recursion paths, so just Match.
-	if tokenIndex == len(self.tokens)-1 {
-		matched, pattern := token.Matches(haystack, pos, self.directorySeparator)
-		//            log.Printf("Last token; matched=%v pattern=%s", matched, pattern)
-		if matched {
-			return &subMatch{
-				startPos:       pos,
-				matchedPattern: pattern,
-			}
-		} else {
-			return nil
-		}
+func (self *Glob) find(haystack string, text *normalizedText, from int, anchored bool, matchToEnd bool) *Match {
+	caps, start, end, ok := self.run(text, from, anchored, matchToEnd)
+	if !ok {
+		return nil
 	}
+	return self.newMatch(haystack, text, caps, start, end)
+}
 
-	// Not the last token, so we need to check for multiple possibilities
-	// given a recursive token
-	if token.CanHaveMultipleAnswers() {
-		//            log.Printf("AllMatchedPatterns of '%s' pos=%d", haystack, pos)
-		if token.CanMatchZeroCharacters() {
-			// Try it as if the pattern matched ""
-			nextSubMatch := self._matchRecursive(tokenIndex+1, pos, haystack, matchCompleteString)
-			if nextSubMatch != nil {
-				return &subMatch{
-					startPos:       pos,
-					matchedPattern: "",
-					next:           nextSubMatch,
-				}
-			}
+// Runs the program over the normalized text, from the position from. When
+// anchored, the match must start there; otherwise the leftmost match wins.
+// Returns the capture slots, and the start and end of the match, which are
+// in the normalized text.
+func (self *Glob) run(text *normalizedText, from int, anchored bool, matchToEnd bool) (caps []int, start int, end int, ok bool) {
+	normalized := text.normalized
+	start = -1
+	// Where the match has to stop, which is before the next volume
+	limit := len(normalized)
+
+	switch {
+	case !self.windowsVolumes:
+		caps, ok = self.program.execute(normalized, from, len(normalized), matchToEnd, anchored, false)
+	case anchored:
+		volume, length := splitWindowsVolume(normalized[from:])
+		if volume != self.volume {
+			return nil, 0, 0, false
 		}
-		ctx, cancel := context.WithCancel(context.Background())
-		for pattern := range token.AllMatchedPatterns(ctx, haystack, pos, self.directorySeparator) {
-			//                log.Printf("Got pattern: %s", pattern)
-			nextSubMatch := self._matchRecursive(tokenIndex+1, pos+len(pattern), haystack, matchCompleteString)
-			if nextSubMatch != nil {
-				// Cancel the recursion
-				cancel()
-				return &subMatch{
-					startPos:       pos,
-					matchedPattern: pattern,
-					next:           nextSubMatch,
+		caps, ok = self.program.run(normalized, from+length, len(normalized), matchToEnd)
+		start = from
+	case self.volume == "":
+		// The match must be between the volumes in the text, like Match
+		// which doesn't match a volume with a pattern that has none
+		gapStart := 0
+		for _, volume := range append(findWindowsVolumes(normalized), volumeSpan{start: len(normalized)}) {
+			if gapStart < from {
+				gapStart = from
+			}
+			if volume.start >= from && (!matchToEnd || volume.start == len(normalized)) {
+				limit = volume.start
+				caps, ok = self.program.search(normalized, gapStart, limit, matchToEnd)
+				if ok {
+					break
 				}
 			}
+			gapStart = volume.end
 		}
-		// No match.
-		return nil
-	} else {
-		// It wasn't a recursive token, so just Match
-		matched, pattern := token.Matches(haystack, pos, self.directorySeparator)
-		//            log.Printf("Not-last token; matched=%v pattern=%s", matched, pattern)
-		if matched {
-			nextSubMatch := self._matchRecursive(tokenIndex+1, pos+len(pattern), haystack, matchCompleteString)
-			return &subMatch{
-				startPos:       pos,
-				matchedPattern: pattern,
-				next:           nextSubMatch,
+	default:
+		// The match must start with the pattern's volume, and stop before
+		// the next one
+		volumes := findWindowsVolumes(normalized)
+		for i, volume := range volumes {
+			limit = len(normalized)
+			if i+1 < len(volumes) {
+				limit = volumes[i+1].start
+			}
+			if volume.start < from || volume.volume != self.volume || (matchToEnd && limit != len(normalized)) {
+				continue
+			}
+			caps, ok = self.program.run(normalized, volume.end, limit, matchToEnd)
+			if ok {
+				start = volume.start
+				break
 			}
-		} else {
-			return nil
 		}
 	}
-	// Cannot reach here
-}
+	if !ok {
+		return nil, 0, 0, false
+	}
 
-func (self *Glob) _matchNoRecursive(haystack string, matchCompleteString bool) *Match {
-	m := &Match{}
-	pos := 0
-	for _, token := range self.tokens {
-		matched, pattern := token.Matches(haystack, pos, self.directorySeparator)
-		if matched {
-			m.matchedStrings = append(m.matchedStrings, pattern)
-		} else {
-			// No recursive at all in this function, so we can return now.
-			return nil
+	// The last token is greedy. The program prefers alternatives in their
+	// order, so when the end is free, find the longest match of the token
+	// from where it starts.
+	if !matchToEnd && self.lastToken != nil {
+		last := 2 * (len(self.tokens) - 1)
+		if lastCaps, longest := self.lastToken.longest(normalized, caps[last], limit); longest && lastCaps[1] > caps[last+1] {
+			caps = append([]int(nil), caps...)
+			caps[last+1] = lastCaps[1]
 		}
-		pos += len(pattern)
 	}
 
-	m.lastPosition = pos
+	// The last slot holds where the program started
+	programStart := caps[len(caps)-1]
+	if start == -1 {
+		start = programStart
+	}
+	end = programStart
+	if len(self.tokens) > 0 {
+		end = caps[2*len(self.tokens)-1]
+	}
+	return caps, start, end, true
+}
+
+func (self *Glob) newMatch(haystack string, text *normalizedText, caps []int, start int, end int) *Match {
+	m := &Match{
+		matchedStrings:    make([]string, len(self.tokens)),
+		matchedSpans:      make([][2]int, len(self.tokens)),
+		wildcardPositions: self.wildcardPositions,
+		wildcardNames:     self.wildcardNames,
+		firstPosition:     text.originalOffset(start),
+		lastPosition:      text.originalOffset(end),
+	}
+	for i := range self.tokens {
+		start, end := text.originalOffset(caps[2*i]), text.originalOffset(caps[2*i+1])
+		m.matchedStrings[i] = haystack[start:end]
+		m.matchedSpans[i] = [2]int{start, end}
+	}
 	return m
 }
@@ -1,6 +1,10 @@
 package globingo
 
 import (
+	"math/rand"
+	"strings"
+
+	"github.com/pkg/errors"
 	. "gopkg.in/check.v1"
 )
 
@@ -68,3 +72,437 @@ func (s *MySuite) TestStartsWith(c *C) {
 	c.Check(match.Length(), Equals, len("fooze"))
 	c.Check(haystack[match.Length():], Equals, "/bat/x/bar.c")
 }
+
+func (s *MySuite) TestQuoteMeta(c *C) {
+	names := []string{
+		"plain.txt",
+		"a*b?c[d]e{f,g}h\\i",
+		"weird (1) | copy.txt",
+		"[!x]-@(y)",
+		"日本語*.txt",
+	}
+
+	for _, name := range names {
+		for _, style := range []PathStyle{UnixStyle, WindowsStyle} {
+			pattern := QuoteMeta(name, style)
+			glob, err := New(pattern, style, false, ExtGlob(true))
+			c.Assert(err, IsNil, Commentf("%q", pattern))
+			c.Check(glob.NumWildcards(), Equals, 0, Commentf("%q", pattern))
+			c.Check(glob.Match(name), NotNil, Commentf("%q", pattern))
+		}
+	}
+
+	c.Check(QuoteMeta("a*b\\c", UnixStyle), Equals, "a\\*b\\\\c")
+	c.Check(QuoteMeta("a*b\\c", WindowsStyle), Equals, "a[*]b\\c")
+
+	// Quoted names can be embedded into larger patterns
+	glob, err := New("*/"+QuoteMeta("[draft] {v2}", UnixStyle)+".*", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("docs/[draft] {v2}.md"), NotNil)
+	c.Check(glob.Match("docs/d {v2}.md"), IsNil)
+
+	// An escaped '*' followed by a wildcard isn't '**', even when not recursive
+	for _, style := range []PathStyle{UnixStyle, WindowsStyle} {
+		glob, err = New(QuoteMeta("x*", style)+"*", style, false)
+		c.Assert(err, IsNil, Commentf("%v", style))
+		c.Check(glob.NumWildcards(), Equals, 1)
+		c.Check(glob.Match("x*yz"), NotNil)
+		c.Check(glob.Match("xyz"), IsNil)
+	}
+	glob, err = New(`a\**`, UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("a*b"), NotNil)
+	_, err = New(`a\***`, UnixStyle, false)
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestNewWithOptions(c *C) {
+	glob, err := NewWithOptions("foo/**/*.C", Style(UnixStyle), Recursive(true), CaseInsensitive(true))
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("foo/bar/baz.c"), NotNil)
+	c.Check(glob.Match(`foo\bar\baz.c`), IsNil)
+
+	// Not recursive by default
+	_, err = NewWithOptions("foo/**", Style(UnixStyle))
+	c.Assert(err, NotNil)
+
+	// Later options override earlier ones, including the arguments to New
+	glob, err = New("*.c", WindowsStyle, false, Style(UnixStyle))
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("a/b.c"), IsNil)
+	c.Check(glob.Match(`a\b.c`), NotNil)
+
+	_, err = NewWithOptions("foo", Style(PathStyle(42)))
+	c.Assert(err, NotNil)
+}
+
+func (s *MySuite) TestSplitWindowsVolume(c *C) {
+	tests := []struct {
+		path   string
+		volume string
+		length int
+	}{
+		{`C:\Users`, `C:`, 2},
+		{`c:/Users`, `C:`, 2},
+		{`c:relative`, `C:`, 2},
+		{`\\server\share\dir`, `\\SERVER\SHARE`, 14},
+		{`//Server/Share`, `\\SERVER\SHARE`, 14},
+		{`\\?\C:\Users`, `C:`, 6},
+		{`\\.\c:\Users`, `C:`, 6},
+		{`\\?\UNC\server\share\dir`, `\\SERVER\SHARE`, 20},
+		{`\\.\PhysicalDrive0`, `\\.\PHYSICALDRIVE0`, 18},
+		{`\\?\Volume{1}\dir`, `\\?\VOLUME{1}`, 13},
+		// Not volumes
+		{`Users\me`, ``, 0},
+		{`\Users`, ``, 0},
+		{`\\server`, ``, 0},
+		{`\\server\`, ``, 0},
+		{`1:\x`, ``, 0},
+		{``, ``, 0},
+	}
+
+	for _, test := range tests {
+		volume, length := splitWindowsVolume(test.path)
+		c.Check(volume, Equals, test.volume, Commentf(test.path))
+		c.Check(length, Equals, test.length, Commentf(test.path))
+	}
+}
+
+func (s *MySuite) TestParse(c *C) {
+	pattern := `src/{a,b*}/[^x-z]?\*{1..9}/**/*.go`
+	nodes, err := Parse(pattern, Style(UnixStyle), Recursive(true))
+	c.Assert(err, IsNil)
+
+	var kinds []NodeKind
+	var texts []string
+	var wildcards []int
+	for _, node := range nodes {
+		kinds = append(kinds, node.Kind)
+		texts = append(texts, pattern[node.Start:node.End])
+		wildcards = append(wildcards, node.Wildcard)
+	}
+	c.Check(kinds, DeepEquals, []NodeKind{TextNode, AlternationNode, TextNode, CharSetNode, AnyCharNode,
+		TextNode, NumericRangeNode, TextNode, GlobStarNode, TextNode, StarNode, TextNode})
+	c.Check(texts, DeepEquals, []string{"src/", "{a,b*}", "/", "[^x-z]", "?", `\*`, "{1..9}", "/",
+		"**", "/", "*", ".go"})
+	c.Check(wildcards, DeepEquals, []int{0, 1, 0, 2, 3, 0, 4, 0, 5, 0, 6, 0})
+
+	// Escapes are removed from the text
+	c.Check(nodes[5].Text, Equals, "*")
+
+	c.Check(nodes[3].Inverted, Equals, true)
+	c.Check(nodes[3].Ranges, DeepEquals, []CharRange{{From: 'x', To: 'z'}})
+	c.Check(nodes[6].From, Equals, int64(1))
+	c.Check(nodes[6].To, Equals, int64(9))
+	c.Check(nodes[8].DirectoriesOnly, Equals, true)
+
+	// Alternatives have their own nodes, with spans in the whole pattern
+	alternatives := nodes[1].Alternatives
+	c.Assert(len(alternatives), Equals, 2)
+	c.Check(alternatives[0], DeepEquals, []Node{{Kind: TextNode, Start: 5, End: 6, Text: "a"}})
+	c.Assert(len(alternatives[1]), Equals, 2)
+	c.Check(alternatives[1][1].Kind, Equals, StarNode)
+	c.Check(pattern[alternatives[1][1].Start:alternatives[1][1].End], Equals, "*")
+
+	// A Glob has the same nodes
+	glob, err := New(pattern, UnixStyle, true)
+	c.Assert(err, IsNil)
+	c.Check(glob.Tokens(), DeepEquals, nodes)
+
+	// Syntax errors are reported as by New
+	_, err = Parse("[abc", Style(UnixStyle))
+	c.Assert(err, NotNil)
+}
+
+func (s *MySuite) TestParseMoreNodes(c *C) {
+	pattern := `C:\[[:alpha:]_]\!(*.tmp|{a,b})`
+	nodes, err := Parse(pattern, Style(WindowsStyle), ExtGlob(true))
+	c.Assert(err, IsNil)
+	c.Assert(len(nodes), Equals, 5)
+
+	c.Check(nodes[0], DeepEquals, Node{Kind: VolumeNode, Start: 0, End: 2, Text: "C:"})
+	c.Check(nodes[2].Kind, Equals, CharSetNode)
+	c.Check(nodes[2].Classes, DeepEquals, []string{"alpha"})
+	c.Check(nodes[2].Wildcard, Equals, 1)
+	c.Check(nodes[4].Kind, Equals, ExtGlobNode)
+	c.Check(nodes[4].Operator, Equals, '!')
+	c.Check(pattern[nodes[4].Start:nodes[4].End], Equals, "!(*.tmp|{a,b})")
+	c.Assert(len(nodes[4].Alternatives), Equals, 2)
+	c.Check(nodes[4].Alternatives[1][0].Kind, Equals, AlternationNode)
+	c.Check(pattern[nodes[4].Alternatives[1][0].Start:nodes[4].Alternatives[1][0].End], Equals, "{a,b}")
+
+	// The merged text of several escapes spans all of them
+	pattern = `a[*]b[?]`
+	nodes, err = Parse(pattern, Style(UnixStyle))
+	c.Assert(err, IsNil)
+	c.Check(nodes, DeepEquals, []Node{{Kind: TextNode, Start: 0, End: 8, Text: "a*b?"}})
+
+	// A named wildcard
+	nodes, err = Parse("{year:*}", Style(UnixStyle))
+	c.Assert(err, IsNil)
+	c.Assert(len(nodes), Equals, 1)
+	c.Check(nodes[0].Kind, Equals, AlternationNode)
+	c.Check(nodes[0].Name, Equals, "year")
+	c.Check(nodes[0].Wildcard, Equals, 1)
+	c.Check(nodes[0].Alternatives[0][0].Kind, Equals, StarNode)
+
+	c.Check(GlobStarNode.String(), Equals, "GlobStar")
+}
+
+func (s *MySuite) TestSyntaxError(c *C) {
+	tests := []struct {
+		pattern    string
+		kind       SyntaxErrorKind
+		offset     int
+		runeOffset int
+	}{
+		{"src/[abc", UnterminatedBracket, 4, 4},
+		{"[[:alpha]", UnterminatedClass, 1, 1},
+		{"a[[:bogus:]]", UnknownClass, 2, 2},
+		{"[a\xff]", InvalidUTF8, 2, 2},
+		{"x[a-a]", EmptyRange, 1, 1},
+		{"x[z-a]", InvertedRange, 1, 1},
+		{"日本{a,b", UnterminatedBrace, 6, 2},
+		{"@(a|b", UnterminatedParenthesis, 1, 1},
+		{"a\\", TrailingBackslash, 1, 1},
+		{"ab/**/c", DisallowedGlobStar, 3, 3},
+		{"{a,b**}", DisallowedGlobStar, 4, 4},
+		{"{a:x}/{a:y}", DuplicateName, 6, 6},
+		{"{x,{a:y}}", NestedName, 3, 3},
+		{"@({a:y})", NestedName, 2, 2},
+		// Positions inside of braces are within the whole pattern
+		{"{a,[b}", UnterminatedBracket, 3, 3},
+	}
+
+	for _, test := range tests {
+		_, err := New(test.pattern, UnixStyle, false, ExtGlob(true), StrictBraces(true))
+		c.Assert(err, NotNil, Commentf(test.pattern))
+
+		var syntaxError *SyntaxError
+		c.Assert(errors.As(err, &syntaxError), Equals, true, Commentf(test.pattern))
+		c.Check(syntaxError.Kind, Equals, test.kind, Commentf(test.pattern))
+		c.Check(syntaxError.Pattern, Equals, test.pattern)
+		c.Check(syntaxError.Offset, Equals, test.offset, Commentf(test.pattern))
+		c.Check(syntaxError.RuneOffset, Equals, test.runeOffset, Commentf(test.pattern))
+	}
+
+	_, err := New("src/[abc", UnixStyle, false)
+	var syntaxError *SyntaxError
+	c.Assert(errors.As(err, &syntaxError), Equals, true)
+	c.Check(syntaxError.Error(), Equals, "Opening bracket at position 5 not terminated")
+	c.Check(syntaxError.Annotated(), Equals, "Opening bracket at position 5 not terminated\nsrc/[abc\n    ^")
+	c.Check(syntaxError.Kind.String(), Equals, "UnterminatedBracket")
+
+	// The caret is placed by runes
+	_, err = Parse("日本[", Style(UnixStyle))
+	c.Assert(errors.As(err, &syntaxError), Equals, true)
+	c.Check(syntaxError.Annotated(), Equals, "Opening bracket at position 7 not terminated\n日本[\n  ^")
+}
+
+func (s *MySuite) TestAllSyntaxErrors(c *C) {
+	type found struct {
+		kind   SyntaxErrorKind
+		offset int
+	}
+	tests := []struct {
+		pattern string
+		errors  []found
+	}{
+		{"a[b/[z-a]/**", []found{{InvertedRange, 1}, {DisallowedGlobStar, 10}}},
+		{"[a-a][z-a]{x", []found{{EmptyRange, 0}, {InvertedRange, 5}, {UnterminatedBrace, 10}}},
+		{"[[:bogus:]x[[:alpha]]", []found{{UnknownClass, 1}, {UnterminatedClass, 12}}},
+		{"{a,[b}/[", []found{{UnterminatedBracket, 3}, {UnterminatedBracket, 7}}},
+		{"@(a|[z-a]/**/x\\", []found{{UnterminatedParenthesis, 1}, {InvertedRange, 4},
+			{DisallowedGlobStar, 10}, {TrailingBackslash, 14}}},
+	}
+
+	for _, test := range tests {
+		_, err := New(test.pattern, UnixStyle, false, ExtGlob(true), StrictBraces(true), AllErrors(true))
+		c.Assert(err, NotNil, Commentf(test.pattern))
+
+		var syntaxErrors SyntaxErrors
+		c.Assert(errors.As(err, &syntaxErrors), Equals, true, Commentf(test.pattern))
+		var got []found
+		for _, syntaxError := range syntaxErrors {
+			got = append(got, found{syntaxError.Kind, syntaxError.Offset})
+		}
+		c.Check(got, DeepEquals, test.errors, Commentf(test.pattern))
+	}
+
+	// Without the option, only the first error in the pattern is returned
+	_, err := New("a[b/[z-a]/**", UnixStyle, false)
+	var syntaxError *SyntaxError
+	c.Assert(errors.As(err, &syntaxError), Equals, true)
+	c.Check(syntaxError.Kind, Equals, InvertedRange)
+	c.Check(syntaxError.Offset, Equals, 1)
+
+	_, err = New("a/**/[z-a]", UnixStyle, false)
+	c.Assert(errors.As(err, &syntaxError), Equals, true)
+	c.Check(syntaxError.Kind, Equals, DisallowedGlobStar)
+	c.Check(syntaxError.Offset, Equals, 2)
+
+	// Each error can be found with errors.As and errors.Is
+	_, err = Parse("x[z-a]/[", Style(UnixStyle), AllErrors(true))
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals,
+		"The start of the range ('z') at 2 is greater than the end of the range ('a')\n"+
+			"Opening bracket at position 8 not terminated")
+	c.Assert(errors.As(err, &syntaxError), Equals, true)
+	c.Check(syntaxError.Kind, Equals, InvertedRange)
+	syntaxErrors := err.(SyntaxErrors)
+	c.Check(errors.Is(err, syntaxErrors[1]), Equals, true)
+
+	// A valid pattern has no errors
+	glob, err := New("a[b-c]/*", UnixStyle, false, AllErrors(true))
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("ab/x"), NotNil)
+}
+
+func (s *MySuite) TestExpand(c *C) {
+	glob, err := New("logs/*/app-*.log", UnixStyle, false)
+	c.Assert(err, IsNil)
+	path, err := glob.Expand("2024", "eu")
+	c.Assert(err, IsNil)
+	c.Check(path, Equals, "logs/2024/app-eu.log")
+	c.Check(glob.Match(path), NotNil)
+
+	_, err = glob.Expand("2024")
+	c.Check(err, NotNil)
+	_, err = glob.Expand("2024/06", "eu")
+	c.Check(err, ErrorMatches, `The value "2024/06" for wildcard #1 doesn't match \*`)
+
+	tests := []struct {
+		pattern string
+		valid   []string
+		invalid []string
+	}{
+		{"?", []string{"a", "日"}, []string{"", "ab", "/"}},
+		{"[a-z]", []string{"q"}, []string{"Q", "1", "ab"}},
+		{"[!a-z]", []string{"Q"}, []string{"q", "/"}},
+		{"{jpg,png}", []string{"jpg", "png"}, []string{"gif", "jpgpng"}},
+		{"{1..12}", []string{"1", "12"}, []string{"0", "13", "x"}},
+		{"**", []string{"", "a/b/c"}, []string{}},
+	}
+	for _, test := range tests {
+		glob, err := New("x/"+test.pattern, UnixStyle, true)
+		c.Assert(err, IsNil, Commentf(test.pattern))
+		for _, value := range test.valid {
+			path, err := glob.Expand(value)
+			c.Check(err, IsNil, Commentf("%s %q", test.pattern, value))
+			c.Check(path, Equals, "x/"+value)
+		}
+		for _, value := range test.invalid {
+			_, err := glob.Expand(value)
+			c.Check(err, NotNil, Commentf("%s %q", test.pattern, value))
+		}
+	}
+
+	// Escaped text is copied without the escapes, and the volume is kept
+	glob, err = New(`C:\data\[*]\*`, WindowsStyle, false)
+	c.Assert(err, IsNil)
+	path, err = glob.Expand("x")
+	c.Assert(err, IsNil)
+	c.Check(path, Equals, `C:\data\*\x`)
+
+	// Dotfiles are checked where the value goes
+	glob, err = New("src/*", UnixStyle, false, ProtectDotfiles(true))
+	c.Assert(err, IsNil)
+	_, err = glob.Expand(".git")
+	c.Check(err, NotNil)
+	glob, err = New("src/a*", UnixStyle, false, ProtectDotfiles(true))
+	c.Assert(err, IsNil)
+	_, err = glob.Expand(".git")
+	c.Check(err, IsNil)
+}
+
+func (s *MySuite) TestExpandGlobStarDirectories(c *C) {
+	glob, err := New("**/*.go", UnixStyle, true)
+	c.Assert(err, IsNil)
+	path, err := glob.Expand("a/b", "x")
+	c.Assert(err, IsNil)
+	c.Check(path, Equals, "a/b/x.go")
+
+	glob, err = New("a/**/b", UnixStyle, true)
+	c.Assert(err, IsNil)
+	path, err = glob.Expand("x")
+	c.Assert(err, IsNil)
+	c.Check(path, Equals, "a/x/b")
+	path, err = glob.Expand("x/y")
+	c.Assert(err, IsNil)
+	c.Check(path, Equals, "a/x/y/b")
+}
+
+func (s *MySuite) TestExpandRoundTrip(c *C) {
+	// Random text goes between the prefix and suffix, so that some of it
+	// matches
+	tests := []struct {
+		pattern string
+		prefix  string
+		suffix  string
+	}{
+		{"**/*.go", "", ".go"},
+		{"a/**/b", "a/", "/b"},
+		{"src/**", "src/", ""},
+		{"*/*-?.[a-z]*", "", ""},
+		{"{x,y}*/**/[0-9]", "x", "/1"},
+		{"**.txt", "", ".txt"},
+	}
+	pieces := []string{"a", "b", "x", "y", "src", "-", ".", "/", "1", "go", ".go", ".txt", "q-z"}
+	random := rand.New(rand.NewSource(1))
+
+	for _, test := range tests {
+		pattern := test.pattern
+		glob, err := New(pattern, UnixStyle, true)
+		c.Assert(err, IsNil, Commentf(pattern))
+
+		matched := 0
+		for i := 0; i < 2000; i++ {
+			var haystack strings.Builder
+			haystack.WriteString(test.prefix)
+			for n := random.Intn(8); n >= 0; n-- {
+				haystack.WriteString(pieces[random.Intn(len(pieces))])
+			}
+			haystack.WriteString(test.suffix)
+			match := glob.Match(haystack.String())
+			if match == nil {
+				continue
+			}
+			matched++
+
+			var values []string
+			for n := 1; n <= glob.NumWildcards(); n++ {
+				text, err := match.GetWildcardText(n)
+				c.Assert(err, IsNil)
+				values = append(values, text)
+			}
+			path, err := glob.Expand(values...)
+			c.Check(err, IsNil, Commentf("%s %q", pattern, haystack.String()))
+			c.Check(path, Equals, haystack.String())
+		}
+		c.Check(matched > 0, Equals, true, Commentf(pattern))
+	}
+}
+
+func (s *MySuite) TestExpandNamed(c *C) {
+	glob, err := New("{year:*}/{month:[01][0-9]}/*.jpg", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	path, err := glob.ExpandNamed(map[string]string{"year": "2024", "month": "06", "3": "beach"})
+	c.Assert(err, IsNil)
+	c.Check(path, Equals, "2024/06/beach.jpg")
+
+	path, err = glob.ExpandNamed(map[string]string{"1": "2024", "2": "06", "3": "beach"})
+	c.Assert(err, IsNil)
+	c.Check(path, Equals, "2024/06/beach.jpg")
+
+	for _, values := range []map[string]string{
+		{"year": "2024", "month": "06"},
+		{"year": "2024", "month": "6", "3": "beach"},
+		{"year": "2024", "month": "06", "3": "beach", "day": "01"},
+		{"year": "2024", "1": "2025", "month": "06", "3": "beach"},
+		{"year": "2024", "month": "06", "4": "beach"},
+	} {
+		_, err := glob.ExpandNamed(values)
+		c.Check(err, NotNil, Commentf("%v", values))
+	}
+}
@@ -2,22 +2,38 @@ package globingo
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"unicode/utf8"
 
-	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
 )
 
 // lexing ideas taken from:
 // https://github.com/golang/go/blob/master/src/text/template/parse/lex.go
 
 type lexerState struct {
-	directorySeparator rune
-	input              string // the string being scanned
-	start              int    // the start position of this token
-	pos                int    // current position within the input
-	width              int    // width of the last rune read (ASCII/UTF)
-	tokens             []tokenInterface
-	err                error
+	separators       separatorSet
+	recursive        bool
+	extGlob          bool
+	backslashEscapes bool
+	caseInsensitive  bool
+	protectDotfiles  bool
+	matchSeparators  bool
+	strictBraces     bool
+	normalization    *norm.Form
+	pattern          string          // the whole pattern, for errors
+	errs             *[]*SyntaxError // collects every error; nil to stop at the first
+	input            string          // the string being scanned
+	offset           int             // position of input within the whole pattern
+	start            int             // the start position of this token
+	pos              int             // current position within the input
+	width            int             // width of the last rune read (ASCII/UTF)
+	tokens           []tokenInterface
+	err              error
+	// The names of the wildcards so far; nil inside of a group, where
+	// wildcards can't be named
+	names map[string]bool
 }
 
 // returns the next rune in the input
@@ -51,6 +67,8 @@ func (l *lexerState) currentText() string {
 
 // adds a new token and resets the counters
 func (l *lexerState) addToken(token tokenInterface) {
+	token.span().start = l.offset + l.start
+	token.span().end = l.offset + l.pos
 	l.tokens = append(l.tokens, token)
 	l.start = l.pos
 }
@@ -62,70 +80,189 @@ func (l *lexerState) ignore() {
 
 // rune position (starts at 1)
 func (l *lexerState) currentPosition() int {
-	return l.pos + 1
+	return l.offset + l.pos + 1
 }
 
-// sets the error and terminates the scan
-func (l *lexerState) errorf(format string, args ...interface{}) stateFunc {
-	l.err = errors.Errorf(format, args...)
-	return nil
+// records a syntax error at the position (which starts at 1). Returns whether
+// to keep scanning, which is only when all errors are being collected; the
+// caller then recovers from the error.
+func (l *lexerState) report(kind SyntaxErrorKind, position int, format string, args ...interface{}) bool {
+	err := newSyntaxError(kind, l.pattern, position-1, fmt.Sprintf(format, args...))
+	if l.errs == nil {
+		l.err = err
+		return false
+	}
+	*l.errs = append(*l.errs, err)
+	return true
+}
+
+// records a syntax error, and continues with the recovery state when all
+// errors are being collected; otherwise terminates the scan
+func (l *lexerState) errorf(recovery stateFunc, kind SyntaxErrorKind, position int, format string, args ...interface{}) stateFunc {
+	if !l.report(kind, position, format, args...) {
+		return nil
+	}
+	return recovery
+}
+
+// recovers from an unterminated '[', '{', '(' or '\' by making the first
+// n bytes of the token plain text
+func lexOpenerAsText(n int) stateFunc {
+	return func(l *lexerState) stateFunc {
+		l.pos = l.start + n
+		l.addToken(&tokenPlainText{
+			text:     l.currentText(),
+			foldCase: l.caseInsensitive,
+		})
+		return lexAnything
+	}
 }
 
 type stateFunc func(*lexerState) stateFunc
 
 const eof = -1
 
-func tokenizePattern(pattern string, directorySeparator rune) ([]tokenInterface, error) {
+func tokenizePattern(pattern string, separators separatorSet, opts ...Option) ([]tokenInterface, error) {
+	return tokenizePatternAt(pattern, 0, separators, opts...)
+}
+
+// Tokenizes the part of a pattern which starts at offset, so that error
+// messages give positions within the whole pattern.
+func tokenizePatternAt(wholePattern string, offset int, separators separatorSet, opts ...Option) ([]tokenInterface, error) {
+	o := newOptions(opts)
+	pattern := wholePattern[offset:]
+
+	// Backslash can't be an escape character when it's a directory separator
+	backslashEscapes := !separators.contains('\\')
+	if o.backslashEscapes != nil {
+		backslashEscapes = *o.backslashEscapes
+	}
+
+	// Windows file names are case-insensitive
+	caseInsensitive := isWindowsStyle(o.style)
+	if o.caseInsensitive != nil {
+		caseInsensitive = *o.caseInsensitive
+	}
+
+	var errs *[]*SyntaxError
+	if o.allErrors {
+		errs = &[]*SyntaxError{}
+	}
 
 	var lexer = lexerState{
-		input:              pattern,
-		directorySeparator: directorySeparator,
+		pattern:          wholePattern,
+		errs:             errs,
+		input:            pattern,
+		offset:           offset,
+		separators:       separators,
+		recursive:        o.recursive,
+		extGlob:          o.extGlob,
+		backslashEscapes: backslashEscapes,
+		caseInsensitive:  caseInsensitive,
+		protectDotfiles:  o.protectDotfiles,
+		matchSeparators:  o.matchSeparators,
+		strictBraces:     o.strictBraces,
+		normalization:    o.normalization,
+		names:            make(map[string]bool),
 	}
 
+	tokens, err := lexer.run()
+	if errs != nil && len(*errs) > 0 {
+		return nil, SyntaxErrors(*errs)
+	}
+	return tokens, err
+}
+
+// Creates a lexer for a piece of the input, such as one alternative
+// inside of braces. Positions in error messages stay relative to the
+// whole pattern.
+func (l *lexerState) subLexer(start int, end int) *lexerState {
+	return &lexerState{
+		pattern:          l.pattern,
+		errs:             l.errs,
+		input:            l.input[start:end],
+		offset:           l.offset + start,
+		separators:       l.separators,
+		recursive:        l.recursive,
+		extGlob:          l.extGlob,
+		backslashEscapes: l.backslashEscapes,
+		caseInsensitive:  l.caseInsensitive,
+		protectDotfiles:  l.protectDotfiles,
+		matchSeparators:  l.matchSeparators,
+		strictBraces:     l.strictBraces,
+		normalization:    l.normalization,
+	}
+}
+
+// runs the state machine over the input and returns the tokens
+func (l *lexerState) run() ([]tokenInterface, error) {
 	var state stateFunc
 	for state = lexAnything; state != nil; {
-		state = state(&lexer)
+		state = state(l)
 	}
 
-	if lexer.err != nil {
-		return nil, lexer.err
+	if l.err != nil {
+		return nil, l.err
 	}
 
 	// The lexing process may have created multiple tokenPlainText sequences.
 	// If so, combine them into single tokenPlainTexts
-	optimizedTokens := make([]tokenInterface, 0, len(lexer.tokens))
+	optimizedTokens := make([]tokenInterface, 0, len(l.tokens))
 
-	for _, token := range lexer.tokens {
+	for _, token := range l.tokens {
 		if len(optimizedTokens) == 0 {
 			optimizedTokens = append(optimizedTokens, token)
 		} else {
 			if token.Type() == kTokenPlainText && optimizedTokens[len(optimizedTokens)-1].Type() == kTokenPlainText {
 				optimizedTokens[len(optimizedTokens)-1].(*tokenPlainText).text +=
 					token.(*tokenPlainText).text
+				optimizedTokens[len(optimizedTokens)-1].span().end = token.span().end
 			} else {
 				optimizedTokens = append(optimizedTokens, token)
 			}
 		}
 	}
 
+	if l.normalization != nil {
+		for _, token := range optimizedTokens {
+			if plainText, ok := token.(*tokenPlainText); ok {
+				plainText.text = l.normalization.String(plainText.text)
+			}
+		}
+	}
+
 	return optimizedTokens, nil
 }
 
 // is it a special character that is or starts a wildcard?
 func isWildcardStart(r rune) bool {
-	return r == '?' || r == '*' || r == '['
+	return r == '?' || r == '*' || r == '[' || r == '{'
+}
+
+// is it a wildcard start, or a backslash escape when those are enabled?
+func (l *lexerState) isWildcardStart(r rune) bool {
+	return isWildcardStart(r) || (r == '\\' && l.backslashEscapes)
 }
 
 // is it any wildcarcd character at all (and thus, can be escape?)
 func isAnyWildcard(r rune) bool {
-	return r == '?' || r == '*' || r == '[' || r == ']'
+	return r == '?' || r == '*' || r == '[' || r == ']' || r == '{' || r == '}' || r == ',' ||
+		r == '(' || r == ')' || r == '|'
+}
+
+// is the input at an extended glob operator, like "@(", and are they enabled?
+func (l *lexerState) atExtGlob() bool {
+	if !l.extGlob || l.pos+1 >= len(l.input) {
+		return false
+	}
+	return strings.IndexByte("?*+@!", l.input[l.pos]) != -1 && l.input[l.pos+1] == '('
 }
 
 func lexAnything(l *lexerState) stateFunc {
 	var r rune
 
 	r = l.peek()
-	if isWildcardStart(r) {
+	if l.isWildcardStart(r) || l.atExtGlob() {
 		return lexWildcardStart
 	} else if r == eof {
 		return nil
@@ -137,12 +274,14 @@ func lexAnything(l *lexerState) stateFunc {
 func lexPlainText(l *lexerState) stateFunc {
 	consumed := false
 	for {
+		atExtGlob := l.atExtGlob()
 		r := l.next()
-		if isWildcardStart(r) || r == eof {
+		if l.isWildcardStart(r) || atExtGlob || r == eof {
 			l.backup()
 			if consumed {
 				l.addToken(&tokenPlainText{
-					text: l.currentText(),
+					text:     l.currentText(),
+					foldCase: l.caseInsensitive,
 				})
 			}
 			if r == eof {
@@ -156,95 +295,471 @@ func lexPlainText(l *lexerState) stateFunc {
 }
 
 func lexWildcardStart(l *lexerState) stateFunc {
+	if l.atExtGlob() {
+		return lexExtGlobStart
+	}
+
 	r := l.next()
 
 	switch r {
 	case '*':
 		nextRune := l.next()
 		if nextRune == '*' {
+			if !l.recursive && !l.report(DisallowedGlobStar, l.offset+l.start+1,
+				"Non-recursive glob pattern '%s' cannot contain '**'", l.pattern) {
+				return nil
+			}
 			afterGlobStar := l.next()
 			l.backup()
 			l.addToken(&tokenMultiCharMultiDirectory{
-				directoriesOnly: afterGlobStar == l.directorySeparator,
+				directoriesOnly: l.separators.contains(afterGlobStar),
+				protectDotfiles: l.protectDotfiles,
 			})
 		} else {
 			l.backup()
-			l.addToken(&tokenMultiCharSingleDirectory{})
+			l.addToken(&tokenMultiCharSingleDirectory{
+				protectDotfiles: l.protectDotfiles,
+			})
 		}
 		return lexAnything
 	case '?':
-		l.addToken(&tokenSingleChar{})
+		l.addToken(&tokenSingleChar{
+			protectDotfiles: l.protectDotfiles,
+			matchSeparators: l.matchSeparators,
+		})
 		return lexAnything
 	case '[':
 		return lexBracketStart
+	case '{':
+		return lexBraceStart
+	case '\\':
+		// Only reached when backslash escapes are enabled
+		if l.next() == eof {
+			return l.errorf(lexOpenerAsText(1), TrailingBackslash, l.currentPosition()-1,
+				"Backslash at position %d does not escape anything", l.currentPosition()-1)
+		}
+		// if previous token is also PlainText, we're creating a sequence of plain text tokens.
+		// After parsing, the calling routine will optimize these sequences into a single
+		// tokenPlainText
+		l.addToken(&tokenPlainText{
+			text:     l.currentText()[1:],
+			foldCase: l.caseInsensitive,
+		})
+		return lexAnything
 	default:
 		panic(fmt.Sprintf("Unexpected rune: '%v'", r))
 	}
 }
 
-// Range: [a-b] or []-}]
-// Inverted range: [^a-b]
-// Escape single character [?] or [[] or []]
+// Set: [abc0-9_] or []-}] or [a-] (']' is a member when first, '-' when first or last)
+// Inverted set: [^a-z] or [!a-z]
+// Named classes inside of a set: [[:alpha:]_] or [[:Greek:]]
+// Escape single character [?] or [[] or []], or with backslash escapes, [\]] or [\-]
 func lexBracketStart(l *lexerState) stateFunc {
 	var inverted bool
+	var ranges []charRange
+	var classes []charClass
 
 	// The position of '['
 	startPos := l.currentPosition() - 1
 
-	firstRune := l.next()
-	if firstRune == '^' {
+	r := l.next()
+	if r == '^' || r == '!' {
 		inverted = true
-		firstRune = l.next()
-
-	} else if firstRune == eof {
-		return l.errorf("Opening bracket at position %d not terminated", startPos)
+		r = l.next()
 	}
 
-	separator := l.next()
-	if separator == '-' {
-		// we're in a range
-		secondRune := l.next()
-		rightBracket := l.next()
-		if rightBracket != ']' {
-			if rightBracket == eof {
-				return l.errorf("Opening bracket at position %d not terminated", startPos)
+	for first := true; first || r != ']'; first = false {
+		if r == eof {
+			return l.errorf(lexOpenerAsText(1), UnterminatedBracket, startPos, "Opening bracket at position %d not terminated", startPos)
+		}
+
+		if r == '[' && l.peek() == ':' {
+			classPos := l.currentPosition() - 1
+			end := strings.Index(l.input[l.pos+1:], ":]")
+			if end == -1 {
+				if !l.report(UnterminatedClass, classPos, "Character class at position %d not terminated", classPos) {
+					return nil
+				}
+				// The '[' is a member
+				ranges = append(ranges, charRange{from: r, to: r})
+				r = l.next()
+				continue
+			}
+			name := l.input[l.pos+1 : l.pos+1+end]
+			tables, ok := lookupCharClass(name)
+			if ok {
+				classes = append(classes, charClass{name: name, tables: tables})
+			} else if !l.report(UnknownClass, classPos, "Unknown character class %q at position %d", name, classPos) {
+				return nil
+			}
+			l.pos += 1 + end + 2
+			r = l.next()
+			continue
+		}
+
+		if r == '\\' && l.backslashEscapes {
+			if r = l.next(); r == eof {
+				return l.errorf(lexOpenerAsText(1), UnterminatedBracket, startPos, "Opening bracket at position %d not terminated", startPos)
+			}
+		}
+
+		if r == utf8.RuneError && l.width == 1 {
+			if !l.report(InvalidUTF8, l.currentPosition()-1,
+				"Invalid UTF-8 at position %d in the set at position %d", l.currentPosition()-1, startPos) {
+				return nil
 			}
-			return l.errorf("Expected right bracket at position %d", l.currentPosition()-1)
+			r = l.next()
+			continue
 		}
-		if firstRune == secondRune {
-			return l.errorf("The start and end of the range at %d are the same", startPos)
+
+		if l.peek() != '-' {
+			ranges = append(ranges, charRange{from: r, to: r})
+			r = l.next()
+			continue
+		}
+
+		// we're in a range, unless the '-' is the last character in the set
+		l.next()
+		to := l.next()
+		if to == eof {
+			return l.errorf(lexOpenerAsText(1), UnterminatedBracket, startPos, "Opening bracket at position %d not terminated", startPos)
 		}
-		if firstRune > secondRune {
-			return l.errorf("The start of the range (%q) at %d is greater than the end of the range (%q)",
-				firstRune, startPos, secondRune)
+		if to == ']' {
+			ranges = append(ranges, charRange{from: r, to: r}, charRange{from: '-', to: '-'})
+			break
+		}
+		if to == '\\' && l.backslashEscapes {
+			if to = l.next(); to == eof {
+				return l.errorf(lexOpenerAsText(1), UnterminatedBracket, startPos, "Opening bracket at position %d not terminated", startPos)
+			}
+		}
+		if to == utf8.RuneError && l.width == 1 {
+			if !l.report(InvalidUTF8, l.currentPosition()-1,
+				"Invalid UTF-8 at position %d in the set at position %d", l.currentPosition()-1, startPos) {
+				return nil
+			}
+		} else if r == to {
+			if !l.report(EmptyRange, startPos, "The start and end of the range at %d are the same", startPos) {
+				return nil
+			}
+			ranges = append(ranges, charRange{from: r, to: r})
+		} else if r > to {
+			if !l.report(InvertedRange, startPos,
+				"The start of the range (%q) at %d is greater than the end of the range (%q)",
+				r, startPos, to) {
+				return nil
+			}
+		} else {
+			ranges = append(ranges, charRange{from: r, to: to})
 		}
-		l.addToken(&tokenRange{
-			from:     firstRune,
-			to:       secondRune,
-			inverted: inverted,
+		r = l.next()
+	}
+
+	if !inverted && len(classes) == 0 && len(ranges) == 1 && ranges[0].from == ranges[0].to {
+		// we're in an escape sequence
+		// if previous token is also PlainText, we're creating a sequence of plain text tokens.
+		// After parsing, the calling routine will optimize these sequences into a single
+		// tokenPlainText
+		l.addToken(&tokenPlainText{
+			text:     string(ranges[0].from),
+			foldCase: l.caseInsensitive,
 		})
 		return lexAnything
+	}
 
-	} else if separator == ']' {
-		// we're in an escape sequence
-		if isAnyWildcard(firstRune) {
-			// if previous token is also PlainText, we're creating a sequence of plain text tokens.
-			// After parsing, the calling routine will optimize these sequences into a single
-			// tokenPlainText
-			l.addToken(&tokenPlainText{
-				text: string(firstRune),
+	l.addToken(&tokenCharSet{
+		ranges:          ranges,
+		classes:         classes,
+		inverted:        inverted,
+		foldCase:        l.caseInsensitive,
+		protectDotfiles: l.protectDotfiles,
+		matchSeparators: l.matchSeparators,
+	})
+	return lexAnything
+}
+
+// Alternation: {a,b,c}
+// Each alternative is itself a pattern, so braces can be nested: {a,b{c,d}}
+func lexBraceStart(l *lexerState) stateFunc {
+	// The position of '{'
+	startPos := l.currentPosition() - 1
+
+	end, commas := l.findBraceEnd(l.pos)
+	if end == -1 {
+		// As in shells, the '{' is plain text
+		if !l.strictBraces {
+			return lexOpenerAsText(1)
+		}
+		return l.errorf(lexOpenerAsText(1), UnterminatedBrace, startPos, "Opening brace at position %d not terminated", startPos)
+	}
+
+	if len(commas) == 0 {
+		if from, to, width, ok := parseNumericRange(l.input[l.pos:end]); ok {
+			if from > to {
+				from, to = to, from
+			}
+			l.pos = end + 1
+			l.addToken(&tokenNumericRange{
+				from:  from,
+				to:    to,
+				width: width,
 			})
 			return lexAnything
-		} else {
-			return l.errorf("Use of [] escape sequence not required for %q at position %d",
-				firstRune, startPos)
 		}
+	}
 
-	} else if separator == eof {
-		return l.errorf("Opening bracket at position %d not terminated", startPos)
+	// A named wildcard: {name:pattern}
+	var name string
+	altStart := l.pos
+	if len(commas) == 0 {
+		n := wildcardNameLength(l.input[l.pos:end])
+		if n == 0 {
+			// As in shells, braces without a comma are plain text, but
+			// what is inside of them isn't
+			return lexOpenerAsText(1)
+		}
+		name = l.input[l.pos : l.pos+n]
+		altStart = l.pos + n + 1
+		if !l.addName(name, startPos) {
+			return nil
+		}
+	}
 
-	} else {
-		// A syntax error
-		return l.errorf("Bracket syntax error (neither range nor escape) at position %d", startPos)
+	var alternatives [][]tokenInterface
+	for _, altEnd := range append(commas, end) {
+		tokens, err := l.subLexer(altStart, altEnd).run()
+		if err != nil {
+			l.err = err
+			return nil
+		}
+		alternatives = append(alternatives, tokens)
+		altStart = altEnd + 1
+	}
+
+	// Skip past the closing brace
+	l.pos = end + 1
+	l.addToken(&tokenAlternation{
+		name:         name,
+		alternatives: alternatives,
+	})
+	return lexAnything
+}
+
+// Returns the length of the name at the start of a brace's text, like the
+// "year" in {year:*}, or 0 when there is none.
+func wildcardNameLength(text string) int {
+	colon := strings.IndexByte(text, ':')
+	if colon <= 0 || !isWildcardName(text[:colon]) {
+		return 0
+	}
+	return colon
+}
+
+// A wildcard name is a letter or '_', followed by letters, digits and '_'
+func isWildcardName(name string) bool {
+	for i, r := range name {
+		if !(r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || (i > 0 && '0' <= r && r <= '9')) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// Records the name of a wildcard, from the brace at the position. Returns
+// whether to keep scanning.
+func (l *lexerState) addName(name string, position int) bool {
+	if l.names == nil {
+		return l.report(NestedName, position,
+			"The wildcard %q at position %d is inside of a group, so it can't be named", name, position)
+	}
+	if l.names[name] {
+		return l.report(DuplicateName, position,
+			"The wildcard name %q at position %d is already used", name, position)
+	}
+	l.names[name] = true
+	return true
+}
+
+// Extended glob: @(a|b), ?(a|b), *(a|b), +(a|b), !(a|b)
+// Each pattern in the list is itself a pattern, so these can be nested.
+func lexExtGlobStart(l *lexerState) stateFunc {
+	// The position of the operator
+	startPos := l.currentPosition()
+
+	operator := l.next()
+	// Skip the '('
+	l.next()
+
+	end, bars := l.findParenEnd(l.pos)
+	if end == -1 {
+		return l.errorf(lexOpenerAsText(2), UnterminatedParenthesis, startPos+1,
+			"Opening parenthesis at position %d not terminated", startPos+1)
+	}
+
+	var alternatives [][]tokenInterface
+	altStart := l.pos
+	for _, altEnd := range append(bars, end) {
+		tokens, err := l.subLexer(altStart, altEnd).run()
+		if err != nil {
+			l.err = err
+			return nil
+		}
+		alternatives = append(alternatives, tokens)
+		altStart = altEnd + 1
+	}
+
+	// Skip past the closing parenthesis
+	l.pos = end + 1
+	l.addToken(&tokenExtGlob{
+		operator:        operator,
+		alternatives:    alternatives,
+		protectDotfiles: l.protectDotfiles,
+	})
+	return lexAnything
+}
+
+// Given the position just after an opening parenthesis, returns the position
+// of the matching closing parenthesis, and the positions of the '|' which
+// separate the top-level patterns. The position is -1 if the parenthesis is
+// not terminated.
+func (l *lexerState) findParenEnd(start int) (int, []int) {
+	input := l.input
+	var bars []int
+	depth := 0
+
+	for pos := start; pos < len(input); {
+		r, w := utf8.DecodeRuneInString(input[pos:])
+		pos += w
+
+		switch r {
+		case '\\':
+			if l.backslashEscapes {
+				_, w = utf8.DecodeRuneInString(input[pos:])
+				pos += w
+			}
+		case '[':
+			// Parentheses and bars inside of brackets are not special
+			pos = l.findBracketEnd(pos)
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return pos - w, bars
+			}
+			depth--
+		case '|':
+			if depth == 0 {
+				bars = append(bars, pos-w)
+			}
+		}
+	}
+	return -1, nil
+}
+
+// Given the position just after an opening brace, returns the position of
+// the matching closing brace, and the positions of the commas which separate
+// the top-level alternatives. The position is -1 if the brace is not terminated.
+func (l *lexerState) findBraceEnd(start int) (int, []int) {
+	input := l.input
+	var commas []int
+	depth := 0
+
+	for pos := start; pos < len(input); {
+		r, w := utf8.DecodeRuneInString(input[pos:])
+		pos += w
+
+		switch r {
+		case '\\':
+			if l.backslashEscapes {
+				_, w = utf8.DecodeRuneInString(input[pos:])
+				pos += w
+			}
+		case '[':
+			// Braces and commas inside of brackets are not special
+			pos = l.findBracketEnd(pos)
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return pos - w, commas
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				commas = append(commas, pos-w)
+			}
+		}
+	}
+	return -1, nil
+}
+
+// Given the position just after an opening bracket, returns the position
+// just after the closing bracket. If the bracket is not terminated, the
+// given position is returned; the bracket lexer will report the error.
+func (l *lexerState) findBracketEnd(start int) int {
+	input := l.input
+	pos := start
+	if pos < len(input) && (input[pos] == '^' || input[pos] == '!') {
+		pos++
+	}
+
+	// The first character is always a member, even if it is ']'
+	for first := true; pos < len(input); first = false {
+		if input[pos] == ']' && !first {
+			return pos + 1
+		}
+		// Skip over escaped characters and named classes, which can be ']'
+		if input[pos] == '\\' && l.backslashEscapes {
+			pos++
+		} else if strings.HasPrefix(input[pos:], "[:") {
+			if end := strings.Index(input[pos+2:], ":]"); end != -1 {
+				pos += 2 + end + 2
+				continue
+			}
+		}
+		_, w := utf8.DecodeRuneInString(input[pos:])
+		pos += w
+	}
+	return start
+}
+
+// Parses the inside of a numeric range brace, like "1..20" or "001..120".
+// If either end of the range has a leading zero, the numbers are zero-padded
+// to the width of the longer end, and the width is returned. Otherwise the
+// width is 0. ok is false if the text is not a numeric range.
+func parseNumericRange(text string) (from int64, to int64, width int, ok bool) {
+	parts := strings.Split(text, "..")
+	if len(parts) != 2 {
+		return 0, 0, 0, false
+	}
+
+	padded := false
+	for i, part := range parts {
+		digits := strings.TrimPrefix(part, "-")
+		if len(digits) == 0 || strings.Trim(digits, "0123456789") != "" {
+			return 0, 0, 0, false
+		}
+		if len(digits) > 1 && digits[0] == '0' {
+			padded = true
+		}
+		if len(digits) > width {
+			width = len(digits)
+		}
+
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		if i == 0 {
+			from = n
+		} else {
+			to = n
+		}
+	}
+
+	if !padded {
+		width = 0
 	}
+	return from, to, width, true
 }
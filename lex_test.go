@@ -9,43 +9,43 @@ func (s *MySuite) TestLexSingle(c *C) {
 	var err error
 
 	// plain text
-	tokens, err = tokenizePattern("foo", kUnixStyle)
+	tokens, err = tokenizePattern("foo", separatorSet{kUnixStyle})
 	c.Assert(err, IsNil)
 	c.Assert(len(tokens), Equals, 1)
 	c.Check(tokens[0].Type(), Equals, kTokenPlainText)
 
 	// single char
-	tokens, err = tokenizePattern("?", kUnixStyle)
+	tokens, err = tokenizePattern("?", separatorSet{kUnixStyle})
 	c.Assert(err, IsNil)
 	c.Assert(len(tokens), Equals, 1)
 	c.Check(tokens[0].Type(), Equals, kTokenSingleChar)
 
 	// range
-	tokens, err = tokenizePattern("[a-b]", kUnixStyle)
+	tokens, err = tokenizePattern("[a-b]", separatorSet{kUnixStyle})
 	c.Assert(err, IsNil)
 	c.Assert(len(tokens), Equals, 1)
-	c.Check(tokens[0].Type(), Equals, kTokenRange)
+	c.Check(tokens[0].Type(), Equals, kTokenCharSet)
 
 	// inverted range
-	tokens, err = tokenizePattern("[^a-b]", kUnixStyle)
+	tokens, err = tokenizePattern("[^a-b]", separatorSet{kUnixStyle})
 	c.Assert(err, IsNil)
 	c.Assert(len(tokens), Equals, 1)
-	c.Check(tokens[0].Type(), Equals, kTokenRange)
+	c.Check(tokens[0].Type(), Equals, kTokenCharSet)
 
 	// escaped chracter
-	tokens, err = tokenizePattern("[?]", kUnixStyle)
+	tokens, err = tokenizePattern("[?]", separatorSet{kUnixStyle})
 	c.Assert(err, IsNil)
 	c.Assert(len(tokens), Equals, 1)
 	c.Check(tokens[0].Type(), Equals, kTokenPlainText)
 
 	// multi-char single directory
-	tokens, err = tokenizePattern("*", kUnixStyle)
+	tokens, err = tokenizePattern("*", separatorSet{kUnixStyle})
 	c.Assert(err, IsNil)
 	c.Assert(len(tokens), Equals, 1)
 	c.Check(tokens[0].Type(), Equals, kTokenMultiCharSingleDirectory)
 
 	// multi-char multi directory
-	tokens, err = tokenizePattern("**", kUnixStyle)
+	tokens, err = tokenizePattern("**", separatorSet{kUnixStyle}, Recursive(true))
 	c.Assert(err, IsNil)
 	c.Assert(len(tokens), Equals, 1)
 	c.Check(tokens[0].Type(), Equals, kTokenMultiCharMultiDirectory)
@@ -55,7 +55,7 @@ func (s *MySuite) TestLexCombineTextPlainSequences(c *C) {
 	var tokens []tokenInterface
 	var err error
 
-	tokens, err = tokenizePattern("foo[?][[][]][*]bar", kUnixStyle)
+	tokens, err = tokenizePattern("foo[?][[][]][*]bar", separatorSet{kUnixStyle})
 	c.Assert(err, IsNil)
 	c.Assert(len(tokens), Equals, 1)
 	c.Check(tokens[0].Type(), Equals, kTokenPlainText)
@@ -66,50 +66,372 @@ func (s *MySuite) TestLexBracketRange(c *C) {
 	var tokens []tokenInterface
 	var err error
 
-	tokens, err = tokenizePattern("[[-]]", kUnixStyle)
+	tokens, err = tokenizePattern("[[-^]", separatorSet{kUnixStyle})
 	c.Assert(err, IsNil)
 	c.Assert(len(tokens), Equals, 1)
-	c.Check(tokens[0].Type(), Equals, kTokenRange)
-	c.Check(tokens[0].(*tokenRange).from, Equals, '[')
-	c.Check(tokens[0].(*tokenRange).to, Equals, ']')
+	c.Check(tokens[0].Type(), Equals, kTokenCharSet)
+	c.Check(tokens[0].(*tokenCharSet).ranges, DeepEquals, []charRange{{from: '[', to: '^'}})
+
+	// A '-' before the closing bracket is not a range, so the last ']' is plain text
+	tokens, err = tokenizePattern("[[-]]", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 2)
+	c.Check(tokens[0].(*tokenCharSet).ranges, DeepEquals, []charRange{{from: '[', to: '['}, {from: '-', to: '-'}})
+	c.Check(tokens[1].(*tokenPlainText).text, Equals, "]")
+}
+
+func (s *MySuite) TestLexBracketSet(c *C) {
+	var tokens []tokenInterface
+	var err error
+
+	tokens, err = tokenizePattern("[a-zA-Z_]", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	c.Check(tokens[0].Type(), Equals, kTokenCharSet)
+	c.Check(tokens[0].(*tokenCharSet).inverted, Equals, false)
+	c.Check(tokens[0].(*tokenCharSet).ranges, DeepEquals, []charRange{
+		{from: 'a', to: 'z'}, {from: 'A', to: 'Z'}, {from: '_', to: '_'}})
+
+	// POSIX negation
+	tokens, err = tokenizePattern("[!abc]", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Check(tokens[0].(*tokenCharSet).inverted, Equals, true)
+	c.Check(tokens[0].(*tokenCharSet).ranges, DeepEquals, []charRange{
+		{from: 'a', to: 'a'}, {from: 'b', to: 'b'}, {from: 'c', to: 'c'}})
+
+	// ']' first, '-' first and last
+	tokens, err = tokenizePattern("[]a-]", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Check(tokens[0].(*tokenCharSet).ranges, DeepEquals, []charRange{
+		{from: ']', to: ']'}, {from: 'a', to: 'a'}, {from: '-', to: '-'}})
+
+	tokens, err = tokenizePattern("[^-x]", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Check(tokens[0].(*tokenCharSet).inverted, Equals, true)
+	c.Check(tokens[0].(*tokenCharSet).ranges, DeepEquals, []charRange{{from: '-', to: '-'}, {from: 'x', to: 'x'}})
+
+	tokens, err = tokenizePattern("[-x]", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Check(tokens[0].(*tokenCharSet).ranges, DeepEquals, []charRange{{from: '-', to: '-'}, {from: 'x', to: 'x'}})
+
+	tokens, err = tokenizePattern("[!]]", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Check(tokens[0].(*tokenCharSet).inverted, Equals, true)
+	c.Check(tokens[0].(*tokenCharSet).ranges, DeepEquals, []charRange{{from: ']', to: ']'}})
 }
 
 func (s *MySuite) TestLexBracketErrors(c *C) {
 	var err error
 
-	_, err = tokenizePattern("[a-bxyz", kUnixStyle)
+	_, err = tokenizePattern("[a-bxyz", separatorSet{kUnixStyle})
 	c.Assert(err, NotNil)
-	c.Check(err.Error(), Equals, "Expected right bracket at position 5")
+	c.Check(err.Error(), Equals, "Opening bracket at position 1 not terminated")
 
-	_, err = tokenizePattern("[a-a]", kUnixStyle)
+	_, err = tokenizePattern("[a-a]", separatorSet{kUnixStyle})
 	c.Assert(err, NotNil)
 	c.Check(err.Error(), Equals, "The start and end of the range at 1 are the same")
 
-	_, err = tokenizePattern("[b-a]", kUnixStyle)
+	_, err = tokenizePattern("[xyb-a]", separatorSet{kUnixStyle})
 	c.Assert(err, NotNil)
 	c.Check(err.Error(), Equals, "The start of the range ('b') at 1 is greater than the end of the range ('a')")
 
-	_, err = tokenizePattern("[x]", kUnixStyle)
+	_, err = tokenizePattern("[b-a]", separatorSet{kUnixStyle})
 	c.Assert(err, NotNil)
-	c.Check(err.Error(), Equals, "Use of [] escape sequence not required for 'x' at position 1")
+	c.Check(err.Error(), Equals, "The start of the range ('b') at 1 is greater than the end of the range ('a')")
 
-	_, err = tokenizePattern("[abcdef", kUnixStyle)
+	_, err = tokenizePattern("[abcdef", separatorSet{kUnixStyle})
 	c.Assert(err, NotNil)
-	c.Check(err.Error(), Equals, "Bracket syntax error (neither range nor escape) at position 1")
+	c.Check(err.Error(), Equals, "Opening bracket at position 1 not terminated")
 
-	_, err = tokenizePattern("[", kUnixStyle)
+	_, err = tokenizePattern("[", separatorSet{kUnixStyle})
 	c.Assert(err, NotNil)
 	c.Check(err.Error(), Equals, "Opening bracket at position 1 not terminated")
 
-	_, err = tokenizePattern("[^", kUnixStyle)
+	_, err = tokenizePattern("[^", separatorSet{kUnixStyle})
 	c.Assert(err, NotNil)
 	c.Check(err.Error(), Equals, "Opening bracket at position 1 not terminated")
 
-	_, err = tokenizePattern("[a-", kUnixStyle)
+	_, err = tokenizePattern("[a-", separatorSet{kUnixStyle})
 	c.Assert(err, NotNil)
 	c.Check(err.Error(), Equals, "Opening bracket at position 1 not terminated")
 
-	_, err = tokenizePattern("[a-b", kUnixStyle)
+	_, err = tokenizePattern("[a-b", separatorSet{kUnixStyle})
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Opening bracket at position 1 not terminated")
+}
+
+func (s *MySuite) TestLexBraceAlternation(c *C) {
+	var tokens []tokenInterface
+	var err error
+
+	tokens, err = tokenizePattern("*.{go,proto}", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 3)
+	c.Check(tokens[0].Type(), Equals, kTokenMultiCharSingleDirectory)
+	c.Check(tokens[1].Type(), Equals, kTokenPlainText)
+	c.Check(tokens[2].Type(), Equals, kTokenAlternation)
+
+	alternation := tokens[2].(*tokenAlternation)
+	c.Assert(len(alternation.alternatives), Equals, 2)
+	c.Check(alternation.alternatives[0][0].(*tokenPlainText).text, Equals, "go")
+	c.Check(alternation.alternatives[1][0].(*tokenPlainText).text, Equals, "proto")
+
+	// nested braces, and braces and commas inside of brackets
+	tokens, err = tokenizePattern("{a,b{c,d},[,][}]}", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	alternation = tokens[0].(*tokenAlternation)
+	c.Assert(len(alternation.alternatives), Equals, 3)
+	c.Check(alternation.alternatives[1][1].Type(), Equals, kTokenAlternation)
+	c.Check(alternation.alternatives[2][0].(*tokenPlainText).text, Equals, ",}")
+
+	// empty alternatives are allowed
+	tokens, err = tokenizePattern("foo{,.c}", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 2)
+	alternation = tokens[1].(*tokenAlternation)
+	c.Assert(len(alternation.alternatives), Equals, 2)
+	c.Check(len(alternation.alternatives[0]), Equals, 0)
+}
+
+func (s *MySuite) TestLexBraceLiterals(c *C) {
+	var tokens []tokenInterface
+	var err error
+
+	// Without StrictBraces, an unterminated brace is plain text
+	for _, pattern := range []string{"foo{bar", "foo{a,b", "{a,{b}", "a}b"} {
+		tokens, err = tokenizePattern(pattern, separatorSet{kUnixStyle})
+		c.Assert(err, IsNil, Commentf(pattern))
+		c.Assert(len(tokens), Equals, 1, Commentf(pattern))
+		c.Check(tokens[0].(*tokenPlainText).text, Equals, pattern)
+	}
+
+	// So are braces without a comma, but wildcards inside of them aren't
+	tokens, err = tokenizePattern("x{a}y", separatorSet{kUnixStyle}, StrictBraces(true))
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	c.Check(tokens[0].(*tokenPlainText).text, Equals, "x{a}y")
+
+	tokens, err = tokenizePattern("{a*}", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 3)
+	c.Check(tokens[1].Type(), Equals, kTokenMultiCharSingleDirectory)
+
+	// Inside of an alternation too
+	tokens, err = tokenizePattern("{x,{a}}", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	alternation := tokens[0].(*tokenAlternation)
+	c.Check(alternation.alternatives[1][0].(*tokenPlainText).text, Equals, "{a}")
+}
+
+func (s *MySuite) TestLexBraceErrors(c *C) {
+	var err error
+
+	_, err = tokenizePattern("foo{a,b", separatorSet{kUnixStyle}, StrictBraces(true))
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Opening brace at position 4 not terminated")
+
+	_, err = tokenizePattern("{a,{b}", separatorSet{kUnixStyle}, StrictBraces(true))
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Opening brace at position 1 not terminated")
+
+	// Errors inside of an alternative report the position within the whole pattern
+	_, err = tokenizePattern("x{a,[b-a]}", separatorSet{kUnixStyle})
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "The start of the range ('b') at 5 is greater than the end of the range ('a')")
+}
+
+func (s *MySuite) TestLexNumericRange(c *C) {
+	var tokens []tokenInterface
+	var err error
+
+	tokens, err = tokenizePattern("shard-{1..20}", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 2)
+	c.Check(tokens[1].Type(), Equals, kTokenNumericRange)
+	numeric := tokens[1].(*tokenNumericRange)
+	c.Check(numeric.from, Equals, int64(1))
+	c.Check(numeric.to, Equals, int64(20))
+	c.Check(numeric.width, Equals, 0)
+
+	// zero-padded
+	tokens, err = tokenizePattern("{001..120}", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	numeric = tokens[0].(*tokenNumericRange)
+	c.Check(numeric.from, Equals, int64(1))
+	c.Check(numeric.to, Equals, int64(120))
+	c.Check(numeric.width, Equals, 3)
+
+	// descending and negative
+	tokens, err = tokenizePattern("{5..-5}", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	numeric = tokens[0].(*tokenNumericRange)
+	c.Check(numeric.from, Equals, int64(-5))
+	c.Check(numeric.to, Equals, int64(5))
+
+	// not numeric ranges, so without a comma they are plain text
+	for _, pattern := range []string{"{1..}", "{a..z}", "{1..2..3}"} {
+		tokens, err = tokenizePattern(pattern, separatorSet{kUnixStyle})
+		c.Assert(err, IsNil)
+		c.Assert(len(tokens), Equals, 1)
+		c.Check(tokens[0].Type(), Equals, kTokenPlainText, Commentf(pattern))
+	}
+	tokens, err = tokenizePattern("{1,2..3}", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Check(tokens[0].Type(), Equals, kTokenAlternation)
+}
+
+func (s *MySuite) TestLexBracketClasses(c *C) {
+	var tokens []tokenInterface
+	var err error
+
+	tokens, err = tokenizePattern("[[:alpha:]_]", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	charSet := tokens[0].(*tokenCharSet)
+	c.Assert(len(charSet.classes), Equals, 1)
+	c.Check(charSet.classes[0].name, Equals, "alpha")
+	c.Check(charSet.ranges, DeepEquals, []charRange{{from: '_', to: '_'}})
+	c.Check(charSet.String(), Equals, "[_[:alpha:]]")
+
+	// Unicode categories and scripts
+	tokens, err = tokenizePattern("[^[:Lu:][:Greek:]]", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	charSet = tokens[0].(*tokenCharSet)
+	c.Check(charSet.inverted, Equals, true)
+	c.Assert(len(charSet.classes), Equals, 2)
+	c.Check(charSet.classes[0].name, Equals, "Lu")
+	c.Check(charSet.classes[1].name, Equals, "Greek")
+
+	// Classes can be used inside of braces
+	tokens, err = tokenizePattern("{[[:digit:]],x}", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	c.Check(tokens[0].Type(), Equals, kTokenAlternation)
+
+	_, err = tokenizePattern("a[[:bogus:]]", separatorSet{kUnixStyle})
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Unknown character class \"bogus\" at position 3")
+
+	_, err = tokenizePattern("[[:alpha]", separatorSet{kUnixStyle})
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Character class at position 2 not terminated")
+}
+
+func (s *MySuite) TestLexExtGlob(c *C) {
+	var tokens []tokenInterface
+	var err error
+
+	// Without the option, these are plain text and ordinary wildcards
+	tokens, err = tokenizePattern("@(a|b)", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	c.Check(tokens[0].Type(), Equals, kTokenPlainText)
+
+	tokens, err = tokenizePattern("*(a)", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 2)
+	c.Check(tokens[0].Type(), Equals, kTokenMultiCharSingleDirectory)
+
+	for _, operator := range []rune{'?', '*', '+', '@', '!'} {
+		tokens, err = tokenizePattern("x"+string(operator)+"(a|b*|c(d))y", separatorSet{kUnixStyle}, ExtGlob(true))
+		c.Assert(err, IsNil)
+		c.Assert(len(tokens), Equals, 3)
+		c.Check(tokens[0].(*tokenPlainText).text, Equals, "x")
+		c.Check(tokens[1].Type(), Equals, kTokenExtGlob)
+		c.Check(tokens[2].(*tokenPlainText).text, Equals, "y")
+
+		extGlob := tokens[1].(*tokenExtGlob)
+		c.Check(extGlob.operator, Equals, operator)
+		c.Assert(len(extGlob.alternatives), Equals, 3)
+		c.Check(len(extGlob.alternatives[1]), Equals, 2)
+	}
+
+	// Nested operators
+	tokens, err = tokenizePattern("+(a|@(b|c))", separatorSet{kUnixStyle}, ExtGlob(true))
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	c.Check(tokens[0].(*tokenExtGlob).alternatives[1][0].Type(), Equals, kTokenExtGlob)
+
+	_, err = tokenizePattern("ab!(c|d", separatorSet{kUnixStyle}, ExtGlob(true))
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Opening parenthesis at position 4 not terminated")
+}
+
+func (s *MySuite) TestLexBracketSingleCharacter(c *C) {
+	// A set with one member is the same as plain text
+	tokens, err := tokenizePattern("a[x]b", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	c.Check(tokens[0].(*tokenPlainText).text, Equals, "axb")
+}
+
+func (s *MySuite) TestLexBracketInvalidUTF8(c *C) {
+	// A set's members are runes, so a byte which is not UTF-8 can't be one
+	_, err := tokenizePattern("a[b\xffc]", separatorSet{kUnixStyle})
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Invalid UTF-8 at position 4 in the set at position 2")
+
+	_, err = tokenizePattern("[a-\xff]", separatorSet{kUnixStyle})
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Invalid UTF-8 at position 4 in the set at position 1")
+
+	// Elsewhere the byte is plain text
+	tokens, err := tokenizePattern("a\xff[\u00ff]", separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	c.Check(tokens[0].(*tokenPlainText).text, Equals, "a\xff\u00ff")
+}
+
+func (s *MySuite) TestLexBackslashEscapes(c *C) {
+	var tokens []tokenInterface
+	var err error
+
+	tokens, err = tokenizePattern(`foo\*\?\[\\\{x,y\}`, separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	c.Check(tokens[0].(*tokenPlainText).text, Equals, `foo*?[\{x,y}`)
+
+	// Inside of brackets
+	tokens, err = tokenizePattern(`[\]\-a]`, separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	c.Check(tokens[0].(*tokenCharSet).ranges, DeepEquals, []charRange{
+		{from: ']', to: ']'}, {from: '-', to: '-'}, {from: 'a', to: 'a'}})
+
+	tokens, err = tokenizePattern(`[#-\]]`, separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	c.Check(tokens[0].(*tokenCharSet).ranges, DeepEquals, []charRange{{from: '#', to: ']'}})
+
+	// Inside of braces
+	tokens, err = tokenizePattern(`{a\,b,c\}}`, separatorSet{kUnixStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 1)
+	alternation := tokens[0].(*tokenAlternation)
+	c.Assert(len(alternation.alternatives), Equals, 2)
+	c.Check(alternation.alternatives[0][0].(*tokenPlainText).text, Equals, "a,b")
+	c.Check(alternation.alternatives[1][0].(*tokenPlainText).text, Equals, "c}")
+
+	// Off by default when backslash is the directory separator
+	tokens, err = tokenizePattern(`a\*`, separatorSet{kWindowsStyle})
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 2)
+	c.Check(tokens[0].(*tokenPlainText).text, Equals, `a\`)
+	c.Check(tokens[1].Type(), Equals, kTokenMultiCharSingleDirectory)
+
+	// And can be turned off
+	tokens, err = tokenizePattern(`a\*`, separatorSet{kUnixStyle}, BackslashEscapes(false))
+	c.Assert(err, IsNil)
+	c.Assert(len(tokens), Equals, 2)
+	c.Check(tokens[0].(*tokenPlainText).text, Equals, `a\`)
+
+	_, err = tokenizePattern(`abc\`, separatorSet{kUnixStyle})
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Backslash at position 4 does not escape anything")
+
+	_, err = tokenizePattern(`[a\`, separatorSet{kUnixStyle})
 	c.Assert(err, NotNil)
 	c.Check(err.Error(), Equals, "Opening bracket at position 1 not terminated")
 }
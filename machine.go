@@ -0,0 +1,386 @@
+package globingo
+
+import (
+	"sync"
+	"unicode/utf8"
+)
+
+// Tokens are compiled into a program for a Pike VM, a Thompson NFA which
+// also records capture positions. The VM steps every live thread over the
+// haystack together, one rune at a time, so matching takes time linear in
+// the length of the haystack, and needs no backtracking or goroutines.
+//
+// Numeric ranges and !(...) can only decide whether they matched once they
+// know all of the text they consumed. They save their start position in a
+// slot, and an assert instruction checks the text when the group ends.
+// Inside such a group, threads are told apart by their start position as
+// well as their instruction, so those tokens can take time quadratic in the
+// length of a directory or file name.
+
+type instOp int
+
+const (
+	// Consume one rune, if accepts allows it
+	kInstConsume instOp = iota
+	// Continue at next, and with a lower priority, at alt
+	kInstSplit
+	// Continue at next
+	kInstJump
+	// Save the position in a capture slot
+	kInstSave
+	// Continue only if check allows the text since the position in slot
+	kInstAssert
+	kInstMatch
+)
+
+// The rune passed to a runeMatcher for a byte which is not valid UTF-8. Each
+// such byte is a character of its own. It isn't a member of any set, or a
+// separator, so only wildcards which match anything else can match it.
+const kInvalidByte rune = -1
+
+// Decides whether the rune r, which is w bytes long and starts at position
+// pos of the haystack, can be consumed.
+type runeMatcher func(haystack string, pos int, r rune, w int) bool
+
+// Decides whether the position is acceptable, given the start of the group,
+// or -1 when there is no group.
+type positionChecker func(haystack string, start int, pos int) bool
+
+type inst struct {
+	op      instOp
+	accepts runeMatcher
+	check   positionChecker
+	next    int
+	alt     int
+	slot    int
+	// The slot holding the start of the group which the instruction is
+	// in, or -1
+	keySlot int
+}
+
+type program struct {
+	insts    []inst
+	numSlots int
+	keyed    bool
+	machines sync.Pool
+}
+
+type compiler struct {
+	insts      []inst
+	numSlots   int
+	keySlot    int
+	separators separatorSet
+}
+
+func newCompiler(separators separatorSet, numSlots int) *compiler {
+	return &compiler{
+		numSlots:   numSlots,
+		keySlot:    -1,
+		separators: separators,
+	}
+}
+
+// Compiles a pattern's tokens. The start and end of the Nth token's
+// match are saved in slots 2N and 2N+1. Only the last token is greedy,
+// so earlier wildcards match as little as they can.
+func compileTokens(tokens []tokenInterface, separators separatorSet) *program {
+	c := newCompiler(separators, 2*len(tokens))
+	for i, token := range tokens {
+		c.save(2 * i)
+		token.compile(c, i == len(tokens)-1)
+		c.save(2*i + 1)
+	}
+	return c.program()
+}
+
+// Compiles a sub-program which matches any one of the alternatives.
+func (self *compiler) subprogram(alternatives [][]tokenInterface) *program {
+	c := newCompiler(self.separators, 0)
+	c.alternatives(alternatives, false)
+	return c.program()
+}
+
+func (self *compiler) program() *program {
+	self.emit(inst{op: kInstMatch})
+
+	p := &program{
+		insts:    self.insts,
+		numSlots: self.numSlots,
+	}
+	for _, in := range p.insts {
+		if in.keySlot >= 0 {
+			p.keyed = true
+		}
+	}
+	p.machines.New = func() interface{} {
+		return newMachine(p)
+	}
+	return p
+}
+
+func (self *compiler) emit(in inst) int {
+	in.keySlot = self.keySlot
+	self.insts = append(self.insts, in)
+	return len(self.insts) - 1
+}
+
+func (self *compiler) newSlot() int {
+	self.numSlots++
+	return self.numSlots - 1
+}
+
+func (self *compiler) save(slot int) {
+	self.emit(inst{op: kInstSave, slot: slot})
+}
+
+func (self *compiler) consume(accepts runeMatcher) {
+	self.emit(inst{op: kInstConsume, accepts: accepts})
+}
+
+func (self *compiler) assert(slot int, check positionChecker) {
+	self.emit(inst{op: kInstAssert, slot: slot, check: check})
+}
+
+// Points a split at the body and at the instruction which skips it,
+// preferring the body when greedy.
+func (self *compiler) branch(split int, greedy bool, body int, skip int) {
+	if greedy {
+		self.insts[split].next, self.insts[split].alt = body, skip
+	} else {
+		self.insts[split].next, self.insts[split].alt = skip, body
+	}
+}
+
+// Zero or one times
+func (self *compiler) optional(greedy bool, body func()) {
+	split := self.emit(inst{op: kInstSplit})
+	body()
+	self.branch(split, greedy, split+1, len(self.insts))
+}
+
+// Zero or more times
+func (self *compiler) loop(greedy bool, body func()) {
+	split := self.emit(inst{op: kInstSplit})
+	body()
+	self.emit(inst{op: kInstJump, next: split})
+	self.branch(split, greedy, split+1, len(self.insts))
+}
+
+// One or more times
+func (self *compiler) repeat(greedy bool, body func()) {
+	start := len(self.insts)
+	body()
+	split := self.emit(inst{op: kInstSplit})
+	self.branch(split, greedy, start, split+1)
+}
+
+// Runs body as a group whose text is checked when it ends.
+func (self *compiler) group(body func(), check positionChecker) {
+	slot := self.newSlot()
+	self.save(slot)
+	outer := self.keySlot
+	self.keySlot = slot
+	body()
+	self.assert(slot, check)
+	self.keySlot = outer
+}
+
+func (self *compiler) sequence(tokens []tokenInterface, greedy bool) {
+	for _, token := range tokens {
+		token.compile(self, greedy)
+	}
+}
+
+// Earlier alternatives have a higher priority
+func (self *compiler) alternatives(alternatives [][]tokenInterface, greedy bool) {
+	var jumps []int
+	for i, tokens := range alternatives {
+		if i == len(alternatives)-1 {
+			self.sequence(tokens, greedy)
+			break
+		}
+		split := self.emit(inst{op: kInstSplit, next: len(self.insts) + 1})
+		self.sequence(tokens, greedy)
+		jumps = append(jumps, self.emit(inst{op: kInstJump}))
+		self.insts[split].alt = len(self.insts)
+	}
+	for _, jump := range jumps {
+		self.insts[jump].next = len(self.insts)
+	}
+}
+
+// ============================================================================
+// The VM
+// ============================================================================
+
+type thread struct {
+	pc   int
+	caps []int
+}
+
+// The threads for one position of the haystack, in priority order
+type threadQueue struct {
+	threads []thread
+	// visited[pc] == generation when the instruction was already added
+	visited    []uint32
+	generation uint32
+	// The (pc, group start) pairs which were already added
+	keyed map[[2]int]bool
+}
+
+func (self *threadQueue) reset() {
+	self.threads = self.threads[:0]
+	self.generation++
+	if self.generation == 0 {
+		for i := range self.visited {
+			self.visited[i] = 0
+		}
+		self.generation = 1
+	}
+	for key := range self.keyed {
+		delete(self.keyed, key)
+	}
+}
+
+type machine struct {
+	prog         *program
+	clist, nlist threadQueue
+}
+
+func newMachine(p *program) *machine {
+	m := &machine{prog: p}
+	for _, q := range []*threadQueue{&m.clist, &m.nlist} {
+		q.threads = make([]thread, 0, len(p.insts))
+		q.visited = make([]uint32, len(p.insts))
+		if p.keyed {
+			q.keyed = make(map[[2]int]bool)
+		}
+	}
+	return m
+}
+
+// Follows the instructions which don't consume a rune, adding a thread
+// for each instruction that does.
+func (self *machine) add(q *threadQueue, pc int, haystack string, pos int, caps []int) {
+	in := &self.prog.insts[pc]
+	if in.keySlot >= 0 {
+		key := [2]int{pc, caps[in.keySlot]}
+		if q.keyed[key] {
+			return
+		}
+		q.keyed[key] = true
+	} else {
+		if q.visited[pc] == q.generation {
+			return
+		}
+		q.visited[pc] = q.generation
+	}
+
+	switch in.op {
+	case kInstJump:
+		self.add(q, in.next, haystack, pos, caps)
+	case kInstSplit:
+		self.add(q, in.next, haystack, pos, caps)
+		self.add(q, in.alt, haystack, pos, caps)
+	case kInstSave:
+		// Threads share capture slices, so copy before writing
+		saved := make([]int, len(caps))
+		copy(saved, caps)
+		saved[in.slot] = pos
+		self.add(q, pc+1, haystack, pos, saved)
+	case kInstAssert:
+		start := -1
+		if in.slot >= 0 {
+			start = caps[in.slot]
+		}
+		if in.check(haystack, start, pos) {
+			self.add(q, pc+1, haystack, pos, caps)
+		}
+	default:
+		q.threads = append(q.threads, thread{pc: pc, caps: caps})
+	}
+}
+
+// Runs the program over haystack[start:end], from start. If matchToEnd,
+// the match must end at end; otherwise the highest priority match wins,
+// whatever its length. Returns the capture slots, and whether there was
+// a match. Instructions can look at the haystack outside of the range.
+func (self *program) run(haystack string, start int, end int, matchToEnd bool) ([]int, bool) {
+	return self.execute(haystack, start, end, matchToEnd, true, false)
+}
+
+// Like run, but the longest match wins, whatever its priority. Only the
+// slots of the match which reached furthest are returned.
+func (self *program) longest(haystack string, start int, end int) ([]int, bool) {
+	return self.execute(haystack, start, end, false, true, true)
+}
+
+// Like run, but the match can start at any position from start on. The
+// leftmost match wins. The last of the capture slots holds where the
+// match starts.
+func (self *program) search(haystack string, start int, end int, matchToEnd bool) ([]int, bool) {
+	return self.execute(haystack, start, end, matchToEnd, false, false)
+}
+
+// Unless anchored, a thread is started at each position, with the lowest
+// priority, until there is a match. If longest, the threads run until they
+// all end, and the last match wins.
+func (self *program) execute(haystack string, start int, end int, matchToEnd bool, anchored bool, longest bool) ([]int, bool) {
+	m := self.machines.Get().(*machine)
+	defer self.machines.Put(m)
+
+	m.clist.reset()
+
+	var matched []int
+	found := false
+	for pos := start; ; {
+		if !found && (!anchored || pos == start) {
+			caps := make([]int, self.numSlots+1)
+			for i := range caps {
+				caps[i] = -1
+			}
+			caps[self.numSlots] = pos
+			m.add(&m.clist, 0, haystack, pos, caps)
+		}
+		if len(m.clist.threads) == 0 && (anchored || found) {
+			break
+		}
+
+		var r rune
+		var w int
+		if pos < end {
+			r, w = utf8.DecodeRuneInString(haystack[pos:end])
+			if r == utf8.RuneError && w == 1 {
+				r = kInvalidByte
+			}
+		}
+
+		m.nlist.reset()
+		for _, t := range m.clist.threads {
+			in := &self.insts[t.pc]
+			if in.op == kInstMatch {
+				if longest {
+					matched, found = t.caps, true
+					continue
+				}
+				if !matchToEnd || pos == end {
+					// The remaining threads have a lower priority
+					matched, found = t.caps, true
+					break
+				}
+				continue
+			}
+			if w > 0 && in.accepts(haystack, pos, r, w) {
+				m.add(&m.nlist, t.pc+1, haystack, pos+w, t.caps)
+			}
+		}
+
+		if w == 0 {
+			break
+		}
+		m.clist, m.nlist = m.nlist, m.clist
+		pos += w
+	}
+
+	return matched, found
+}
@@ -1,9 +1,6 @@
 package globingo
 
 import (
-	"fmt"
-	"strconv"
-
 	"github.com/pkg/errors"
 )
 
@@ -12,120 +9,106 @@ type Match struct {
 	// The items in this slice correlate exactly with the
 	// items in the tokens slice in the Glob
 	matchedStrings []string
+	// The start and end of each of those strings in the haystack, in bytes
+	matchedSpans [][2]int
 
 	// Copied from the parent glob object
 	wildcardPositions []int
+	wildcardNames     map[string]int
 
+	// First position (used with Find and EndsWith)
+	firstPosition int
 	// Last position (used with StartsWith)
 	lastPosition int
 }
 
 // Returns the length of the string that was matched
 func (self *Match) Length() int {
+	return self.lastPosition - self.firstPosition
+}
+
+// Returns where the match starts in the haystack, in bytes. It is 0 unless
+// the match was found by Find, FindAll or EndsWith.
+func (self *Match) Start() int {
+	return self.firstPosition
+}
+
+// Returns where the match ends in the haystack, in bytes
+func (self *Match) End() int {
 	return self.lastPosition
 }
 
-// Return the string that the Nth wildcard matched.
-func (self *Match) GetWildcardText(n int) (string, error) {
-	if n == 0 {
-		return "", errors.New("Glob Match wildcard positions start at 1")
+// Returns the index of the token of the Nth wildcard
+func (self *Match) wildcardToken(n int) (int, error) {
+	if n <= 0 {
+		return 0, errors.New("Glob Match wildcard positions start at 1")
 	}
 	if n > len(self.wildcardPositions) {
-		return "", errors.Errorf("This Glob Match has %d wildcards; #%d was requested",
+		return 0, errors.Errorf("This Glob Match has %d wildcards; #%d was requested",
 			len(self.wildcardPositions), n)
 	}
-
-	return self.matchedStrings[self.wildcardPositions[n-1]], nil
+	return self.wildcardPositions[n-1], nil
 }
 
-const (
-	kAnything      = 1
-	kFirstEscape   = 2
-	kNumericEscape = 3
-)
-
-// Using a match, return a sting with tokens like \1, \2, replaced
-// with the patterns matched from the glob.
-func (self *Match) Replace(pattern string) (string, error) {
-
-	var result string
-	var state int = kAnything
-	var numericText string
-
-	for i, runeValue := range pattern {
-		switch state {
-		case kAnything:
-			if runeValue == '\\' {
-				state = kFirstEscape
-				numericText = ""
-			} else {
-				result += string(runeValue)
-			}
-
-		case kFirstEscape:
-			if '0' <= runeValue && runeValue <= '9' {
-				numericText += string(runeValue)
-				state = kNumericEscape
-			} else if runeValue == '\\' {
-				result += "\\"
-				state = kAnything
-			} else {
-				return "", errors.Errorf("\\ should be followed by number or \\, not %s at position %d",
-					string(runeValue), i+1)
-			}
-
-		case kNumericEscape:
-			if '0' <= runeValue && runeValue <= '9' {
-				numericText += string(runeValue)
-				state = kNumericEscape
-			} else {
-				// Convert the number and find that Nth pattern
-				n, err := strconv.Atoi(numericText)
-				if err != nil {
-					panic(fmt.Sprintf("Escaped value %s should be a number but it's not", numericText))
-				}
-				matchedText, err := self.GetWildcardText(n)
-				if err != nil {
-					return "", err
-				}
-				result += matchedText
-				numericText = ""
-				if runeValue == '\\' {
-					state = kFirstEscape
-				} else {
-					result += string(runeValue)
-					state = kAnything
-				}
-			}
+// Return the string that the Nth wildcard matched.
+func (self *Match) GetWildcardText(n int) (string, error) {
+	i, err := self.wildcardToken(n)
+	if err != nil {
+		return "", err
+	}
+	return self.matchedStrings[i], nil
+}
 
-		default:
-			panic(fmt.Sprintf("state = %d", state))
-		}
+// Returns the byte offsets in the haystack of the text that the Nth
+// wildcard matched, so that haystack[start:end] is that text. The offsets
+// are in the original haystack, even when the Normalize option changed it.
+func (self *Match) WildcardSpan(n int) (start, end int, err error) {
+	i, err := self.wildcardToken(n)
+	if err != nil {
+		return 0, 0, err
 	}
+	return self.matchedSpans[i][0], self.matchedSpans[i][1], nil
+}
 
-	// Reached the end of the string. Was the \\ pattern at the end of the string?
-	switch state {
-	case kAnything:
-		// nothing to do
+// Returns the start and end offsets of the text that each wildcard matched,
+// as WildcardSpan does. The first wildcard's span is at index 0.
+func (self *Match) WildcardSpans() [][2]int {
+	spans := make([][2]int, len(self.wildcardPositions))
+	for n, i := range self.wildcardPositions {
+		spans[n] = self.matchedSpans[i]
+	}
+	return spans
+}
 
-	case kFirstEscape:
-		return "", errors.Errorf("\\ should be followed by number or \\ at the end of the string")
+// Return the string that the wildcard with the name matched, like the
+// wildcard {year:*} named "year".
+func (self *Match) GetNamedWildcardText(name string) (string, error) {
+	n, ok := self.wildcardNames[name]
+	if !ok {
+		return "", errors.Errorf("This Glob Match has no wildcard named %q", name)
+	}
+	return self.GetWildcardText(n)
+}
 
-	case kNumericEscape:
-		// Convert the number and find that Nth pattern
-		n, err := strconv.Atoi(numericText)
-		if err != nil {
-			panic(fmt.Sprintf("Escaped value %s should be a number but it's not", numericText))
-		}
-		matchedText, err := self.GetWildcardText(n)
-		if err != nil {
-			return "", err
+// Using a match, return a string with references to wildcards replaced
+// with the text they matched. A reference is \N or ${N}, for the Nth
+// wildcard, or ${name}, for the wildcard with the name, like {name:*}.
+// See CompileReplacement for the rest of the syntax, and for a faster way
+// to use the same template for many matches.
+func (self *Match) Replace(template string) (string, error) {
+	replacement, err := compileReplacement(template, func(part *templatePart) (int, error) {
+		if part.name != "" {
+			n, ok := self.wildcardNames[part.name]
+			if !ok {
+				return 0, errors.Errorf("This Glob Match has no wildcard named %q", part.name)
+			}
+			return n, nil
 		}
-		result += matchedText
-
-	default:
-		panic(fmt.Sprintf("state = %d", state))
+		_, err := self.wildcardToken(part.wildcard)
+		return part.wildcard, err
+	})
+	if err != nil {
+		return "", err
 	}
-
-	return result, nil
+	return replacement.Apply(self), nil
 }
@@ -1,6 +1,13 @@
 package globingo
 
 import (
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
 	. "gopkg.in/check.v1"
 )
 
@@ -281,3 +288,1217 @@ func (s *MySuite) TestReplaceMoreThan9(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(newString, Equals, "L")
 }
+
+func (s *MySuite) TestMatchAlternation(c *C) {
+	glob, err := New("src/*.{go,proto}", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.NumWildcards(), Equals, 2)
+
+	match := glob.Match("src/main.go")
+	c.Assert(match, NotNil)
+	text, err := match.GetWildcardText(2)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "go")
+
+	match = glob.Match("src/api.proto")
+	c.Assert(match, NotNil)
+	text, err = match.GetWildcardText(1)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "api")
+	text, err = match.GetWildcardText(2)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "proto")
+
+	match = glob.Match("src/main.c")
+	c.Assert(match, IsNil)
+
+	match = glob.Match("src/main.gox")
+	c.Assert(match, IsNil)
+}
+
+func (s *MySuite) TestMatchAlternationPrefixes(c *C) {
+	// One alternative is a prefix of the other
+	glob, err := New("foo.{c,cc}", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	match := glob.Match("foo.c")
+	c.Assert(match, NotNil)
+	match = glob.Match("foo.cc")
+	c.Assert(match, NotNil)
+
+	glob, err = New("{a,ab}c", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	match = glob.Match("abc")
+	c.Assert(match, NotNil)
+	text, err := match.GetWildcardText(1)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "ab")
+}
+
+func (s *MySuite) TestMatchAlternationNested(c *C) {
+	glob, err := New("{x,y{1,2},z*}.txt", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.NumWildcards(), Equals, 1)
+
+	for _, haystack := range []string{"x.txt", "y1.txt", "y2.txt", "z.txt", "zebra.txt"} {
+		match := glob.Match(haystack)
+		c.Assert(match, NotNil, Commentf(haystack))
+		text, err := match.GetWildcardText(1)
+		c.Assert(err, IsNil)
+		c.Check(text+".txt", Equals, haystack)
+	}
+
+	for _, haystack := range []string{"y.txt", "y3.txt", "z/a.txt", "w.txt"} {
+		c.Check(glob.Match(haystack), IsNil, Commentf(haystack))
+	}
+}
+
+func (s *MySuite) TestMatchBacktracksToEndOfString(c *C) {
+	// '*' has to try more than its first answer to reach the end of the string
+	glob, err := New("*.c", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	match := glob.Match("a.c.c")
+	c.Assert(match, NotNil)
+	text, err := match.GetWildcardText(1)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "a.c")
+
+	// A failed match after '*' is not a match
+	glob, err = New("*a?", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("ba"), IsNil)
+	c.Check(glob.Match("bab"), NotNil)
+}
+
+func (s *MySuite) TestMatchLiteralBraces(c *C) {
+	glob, err := New("foo{bar/{a}/*", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.NumWildcards(), Equals, 1)
+
+	match := glob.Match("foo{bar/{a}/x")
+	c.Assert(match, NotNil)
+	newString, err := match.Replace("\\1")
+	c.Assert(err, IsNil)
+	c.Check(newString, Equals, "x")
+	c.Check(glob.Match("foobar/a/x"), IsNil)
+
+	_, err = New("foo{bar", UnixStyle, false, StrictBraces(true))
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestReplaceAlternation(c *C) {
+	glob, err := New("*.{jpeg,jpg}", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	for _, haystack := range []string{"photo.jpeg", "photo.jpg"} {
+		match := glob.Match(haystack)
+		c.Assert(match, NotNil)
+
+		newString, err := match.Replace("\\1.jpg")
+		c.Assert(err, IsNil)
+		c.Check(newString, Equals, "photo.jpg")
+	}
+}
+
+func (s *MySuite) TestReplaceNamedWildcards(c *C) {
+	glob, err := New("{year:*}/{month:*}/*.jpg", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.NumWildcards(), Equals, 3)
+
+	match := glob.Match("2024/06/beach.jpg")
+	c.Assert(match, NotNil)
+	text, err := match.GetNamedWildcardText("month")
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "06")
+	text, err = match.GetWildcardText(1)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "2024")
+	_, err = match.GetNamedWildcardText("day")
+	c.Check(err, NotNil)
+
+	newString, err := match.Replace("${year}-${month}-\\3.jpg")
+	c.Assert(err, IsNil)
+	c.Check(newString, Equals, "2024-06-beach.jpg")
+	newString, err = match.Replace("${3}$${1}\\$x$")
+	c.Assert(err, IsNil)
+	c.Check(newString, Equals, "beach$2024$x$")
+
+	_, err = match.Replace("${day}")
+	c.Check(err, NotNil)
+	for _, template := range []string{"${", "${year", "${}", "${a b}", "${-1}"} {
+		_, err = match.Replace(template)
+		c.Check(err, NotNil, Commentf(template))
+	}
+
+	// The body of a named wildcard is a pattern
+	glob, err = New("{ext:jp{e,}g}", UnixStyle, false)
+	c.Assert(err, IsNil)
+	match = glob.Match("jpeg")
+	c.Assert(match, NotNil)
+	text, err = match.GetNamedWildcardText("ext")
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "jpeg")
+	c.Check(glob.Match("jpg"), NotNil)
+	c.Check(glob.Match("ext:jpg"), IsNil)
+
+	// Only an identifier before the ':' is a name
+	glob, err = New("{C:,D:}x", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("D:x"), NotNil)
+	glob, err = New("{1a:b}", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.NumWildcards(), Equals, 0)
+	c.Check(glob.Match("{1a:b}"), NotNil)
+}
+
+func (s *MySuite) TestCheckReplacement(c *C) {
+	glob, err := New("{year:*}/*.jpg", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	c.Check(glob.CheckReplacement("${year}/\\2"), IsNil)
+	c.Check(glob.CheckReplacement("${1}/${2}"), IsNil)
+	c.Check(glob.CheckReplacement("${month}"), ErrorMatches, `.*"month".*`)
+	c.Check(glob.CheckReplacement("\\3"), ErrorMatches, ".*#3.*")
+	c.Check(glob.CheckReplacement("\\0"), NotNil)
+	c.Check(glob.CheckReplacement("${year"), NotNil)
+}
+
+func (s *MySuite) TestCompileReplacement(c *C) {
+	glob, err := New("{name:*}-{n:[0-9]*}.{ext:*}", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	tests := []struct {
+		template string
+		haystack string
+		expected string
+	}{
+		{"\\U\\1\\E-\\2", "report-7.txt", "REPORT-7"},
+		{"\\Ux${name}\\Ly${ext}", "Report-7.TXT", "XREPORTytxt"},
+		{"${n:03}.${ext}", "a-7.txt", "007.txt"},
+		{"${n:03}", "a-1234.txt", "1234"},
+		{"${n:03}", "a-7z.txt", "7z"},
+		{"${name:-untitled}.${3}", "-7.txt", "untitled.txt"},
+		{"${name:-untitled}", "b-7.txt", "b"},
+		{"\\U${name:-untitled}", "-7.txt", "UNTITLED"},
+		{"$$\\$\\\\", "a-7.txt", "$$$\\"},
+	}
+	for _, test := range tests {
+		replacement, err := CompileReplacement(test.template, glob)
+		c.Assert(err, IsNil, Commentf(test.template))
+		c.Check(replacement.String(), Equals, test.template)
+
+		match := glob.Match(test.haystack)
+		c.Assert(match, NotNil, Commentf(test.haystack))
+		c.Check(replacement.Apply(match), Equals, test.expected, Commentf(test.template))
+
+		// Replace understands the same templates
+		newString, err := match.Replace(test.template)
+		c.Assert(err, IsNil)
+		c.Check(newString, Equals, test.expected, Commentf(test.template))
+	}
+
+	// Errors are found when compiling
+	for _, template := range []string{"\\4", "\\0", "${4}", "${size}", "${n:5}", "${n:0x}", "${:-x}", "\\X"} {
+		_, err := CompileReplacement(template, glob)
+		c.Check(err, NotNil, Commentf(template))
+	}
+
+	c.Check(zeroPad("-7", 3), Equals, "-07")
+	c.Check(zeroPad("-", 3), Equals, "-")
+	c.Check(zeroPad("", 3), Equals, "")
+}
+
+func (s *MySuite) TestMatchNumericRange(c *C) {
+	glob, err := New("shard-{1..120}.bin", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.NumWildcards(), Equals, 1)
+
+	for _, haystack := range []string{"shard-1.bin", "shard-7.bin", "shard-99.bin", "shard-120.bin"} {
+		c.Check(glob.Match(haystack), NotNil, Commentf(haystack))
+	}
+	for _, haystack := range []string{"shard-0.bin", "shard-121.bin", "shard-007.bin", "shard-.bin", "shard-x.bin"} {
+		c.Check(glob.Match(haystack), IsNil, Commentf(haystack))
+	}
+
+	match := glob.Match("shard-42.bin")
+	c.Assert(match, NotNil)
+	newString, err := match.Replace("out-\\1.txt")
+	c.Assert(err, IsNil)
+	c.Check(newString, Equals, "out-42.txt")
+}
+
+func (s *MySuite) TestMatchNumericRangePadded(c *C) {
+	glob, err := New("shard-{001..120}.bin", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	for _, haystack := range []string{"shard-001.bin", "shard-007.bin", "shard-099.bin", "shard-120.bin"} {
+		c.Check(glob.Match(haystack), NotNil, Commentf(haystack))
+	}
+	for _, haystack := range []string{"shard-000.bin", "shard-7.bin", "shard-0007.bin", "shard-121.bin"} {
+		c.Check(glob.Match(haystack), IsNil, Commentf(haystack))
+	}
+}
+
+func (s *MySuite) TestMatchNumericRangeFollowedByDigits(c *C) {
+	// The range must not take digits that the rest of the pattern needs
+	glob, err := New("{1..20}0", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	match := glob.Match("100")
+	c.Assert(match, NotNil)
+	text, err := match.GetWildcardText(1)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "10")
+
+	glob, err = New("x{-5..5}", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("x-5"), NotNil)
+	c.Check(glob.Match("x0"), NotNil)
+	c.Check(glob.Match("x-0"), IsNil)
+	c.Check(glob.Match("x-6"), IsNil)
+}
+
+func (s *MySuite) TestMatchCharSet(c *C) {
+	glob, err := New("[a-zA-Z_][a-zA-Z0-9_]*.go", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	for _, haystack := range []string{"main.go", "_test.go", "Foo9.go", "xy.go"} {
+		c.Check(glob.Match(haystack), NotNil, Commentf(haystack))
+	}
+	for _, haystack := range []string{"9main.go", "-x.go", "x.go", ".go"} {
+		c.Check(glob.Match(haystack), IsNil, Commentf(haystack))
+	}
+
+	glob, err = New("[!.]*", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("visible"), NotNil)
+	c.Check(glob.Match(".hidden"), IsNil)
+}
+
+func (s *MySuite) TestMatchCharClasses(c *C) {
+	tests := []struct {
+		pattern string
+		matches []string
+		fails   []string
+	}{
+		{"[[:alpha:]]", []string{"a", "Z", "é", "ж", "日"}, []string{"1", "_", " ", ""}},
+		{"[[:digit:]]", []string{"0", "9", "٣"}, []string{"a", "x"}},
+		{"[[:alnum:]_]", []string{"a", "7", "_"}, []string{"-", "."}},
+		{"[[:upper:]]", []string{"A", "Ж"}, []string{"a", "ж", "1"}},
+		{"[[:lower:]]", []string{"a", "ж"}, []string{"A", "Ж"}},
+		{"[[:space:]]", []string{" ", "\t", "\n", "　"}, []string{"a", "_"}},
+		{"[[:blank:]]", []string{" ", "\t"}, []string{"\n", "a"}},
+		{"[[:punct:]]", []string{"!", ".", "$", "+", "«"}, []string{"a", " "}},
+		{"[[:xdigit:]]", []string{"0", "a", "F"}, []string{"g", "G", "٣"}},
+		{"[[:cntrl:]]", []string{"\x00", "\x1f"}, []string{"a"}},
+		{"[[:L:]]", []string{"a", "日"}, []string{"1"}},
+		{"[[:Greek:]]", []string{"α", "Ω"}, []string{"a", "ж"}},
+		{"[^[:Greek:]]", []string{"a", "ж"}, []string{"α", "Ω"}},
+	}
+
+	for _, test := range tests {
+		glob, err := New(test.pattern, UnixStyle, false)
+		c.Assert(err, IsNil, Commentf(test.pattern))
+
+		for _, haystack := range test.matches {
+			match := glob.Match(haystack)
+			c.Check(match, NotNil, Commentf("%s %q", test.pattern, haystack))
+		}
+		for _, haystack := range test.fails {
+			c.Check(glob.Match(haystack), IsNil, Commentf("%s %q", test.pattern, haystack))
+		}
+	}
+
+	// Whole runes are matched, not bytes
+	glob, err := New("[[:Han:]][[:Han:]].txt", UnixStyle, false)
+	c.Assert(err, IsNil)
+	match := glob.Match("日本.txt")
+	c.Assert(match, NotNil)
+	text, err := match.GetWildcardText(2)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "本")
+}
+
+func (s *MySuite) TestMatchExtGlob(c *C) {
+	tests := []struct {
+		pattern string
+		matches []string
+		fails   []string
+	}{
+		{"@(foo|bar).c", []string{"foo.c", "bar.c"}, []string{".c", "foobar.c", "baz.c"}},
+		{"x?(foo|bar).c", []string{"x.c", "xfoo.c", "xbar.c"}, []string{"xfoobar.c", "xbaz.c"}},
+		{"x*(foo|bar).c", []string{"x.c", "xfoo.c", "xfoobarfoo.c"}, []string{"xbaz.c", "xfo.c"}},
+		{"x+(foo|bar).c", []string{"xfoo.c", "xbarbar.c"}, []string{"x.c", "xbaz.c"}},
+		{"!(*.tmp)", []string{"a.txt", "tmp", "a.tmpx", ""}, []string{"a.tmp", ".tmp", "a/b"}},
+		{"dir/!(foo|bar)/x", []string{"dir/baz/x", "dir/fo/x", "dir//x"}, []string{"dir/foo/x", "dir/bar/x"}},
+		{"+([[:digit:]])-@(a|b)", []string{"1-a", "123-b"}, []string{"-a", "1-c", "x-a"}},
+	}
+
+	for _, test := range tests {
+		glob, err := New(test.pattern, UnixStyle, false, ExtGlob(true))
+		c.Assert(err, IsNil, Commentf(test.pattern))
+
+		for _, haystack := range test.matches {
+			c.Check(glob.Match(haystack), NotNil, Commentf("%s %q", test.pattern, haystack))
+		}
+		for _, haystack := range test.fails {
+			c.Check(glob.Match(haystack), IsNil, Commentf("%s %q", test.pattern, haystack))
+		}
+	}
+}
+
+func (s *MySuite) TestReplaceExtGlob(c *C) {
+	glob, err := New("*.@(jpeg|jpg)", UnixStyle, false, ExtGlob(true))
+	c.Assert(err, IsNil)
+	c.Check(glob.NumWildcards(), Equals, 2)
+
+	match := glob.Match("photo.jpeg")
+	c.Assert(match, NotNil)
+
+	text, err := match.GetWildcardText(2)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "jpeg")
+
+	newString, err := match.Replace("\\1.jpg")
+	c.Assert(err, IsNil)
+	c.Check(newString, Equals, "photo.jpg")
+
+	glob, err = New("!(*.bak)-+([0-9])", UnixStyle, false, ExtGlob(true))
+	c.Assert(err, IsNil)
+
+	match = glob.Match("report-2024")
+	c.Assert(match, NotNil)
+	newString, err = match.Replace("\\2/\\1")
+	c.Assert(err, IsNil)
+	c.Check(newString, Equals, "2024/report")
+}
+
+func (s *MySuite) TestMatchMultiCharMultiDirectoryNotAtDirectory(c *C) {
+	// '**' which is not followed by a separator matches across separators
+	glob, err := New("src/**.go", UnixStyle, true)
+	c.Assert(err, IsNil)
+
+	for _, haystack := range []string{"src/a.go", "src/a/b/c.go", "src/.go", "src/a.go/b.go"} {
+		match := glob.Match(haystack)
+		c.Assert(match, NotNil, Commentf(haystack))
+		text, err := match.GetWildcardText(1)
+		c.Assert(err, IsNil)
+		c.Check("src/"+text+".go", Equals, haystack)
+	}
+
+	for _, haystack := range []string{"src/a.c", "lib/a.go", "src/a.go/b"} {
+		c.Check(glob.Match(haystack), IsNil, Commentf(haystack))
+	}
+
+	glob, err = New("a/**/*.c", UnixStyle, true)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("a/b/c/d.c"), NotNil)
+
+	glob, err = New("**x**", UnixStyle, true)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("x"), NotNil)
+	c.Check(glob.Match("a/b/x/c/d"), NotNil)
+	c.Check(glob.Match("a/b/c/d"), IsNil)
+
+	glob, err = New("a/**", UnixStyle, true)
+	c.Assert(err, IsNil)
+	match := glob.StartsWith("a/b/c")
+	c.Assert(match, NotNil)
+	c.Check(match.Length(), Equals, len("a/b/c"))
+}
+
+func (s *MySuite) TestNewBadStyle(c *C) {
+	_, err := New("foo", PathStyle(42), false)
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Unexpected path style 42")
+}
+
+func (s *MySuite) TestMatchBackslashEscapes(c *C) {
+	glob, err := New(`what\?-*`, UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.NumWildcards(), Equals, 1)
+	c.Check(glob.Match("what?-x"), NotNil)
+	c.Check(glob.Match("whatx-x"), IsNil)
+
+	glob, err = New(`[\]a]\\`, UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match(`]\`), NotNil)
+	c.Check(glob.Match(`a\`), NotNil)
+	c.Check(glob.Match(`\\`), IsNil)
+}
+
+func (s *MySuite) TestMatchCaseInsensitive(c *C) {
+	glob, err := New("*.JPG", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("photo.jpg"), IsNil)
+
+	glob, err = New("*.JPG", UnixStyle, false, CaseInsensitive(true))
+	c.Assert(err, IsNil)
+
+	match := glob.Match("Photo.jpg")
+	c.Assert(match, NotNil)
+	// The wildcard keeps the case of the haystack
+	text, err := match.GetWildcardText(1)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "Photo")
+	c.Check(match.matchedStrings[1], Equals, ".jpg")
+
+	// Ranges and classes
+	glob, err = New("[a-c][[:upper:]]{x,y}", UnixStyle, false, CaseInsensitive(true))
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("Bqy"), NotNil)
+	c.Check(glob.Match("bQX"), NotNil)
+	c.Check(glob.Match("dQX"), IsNil)
+
+	glob, err = New("[^a-c]", UnixStyle, false, CaseInsensitive(true))
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("B"), IsNil)
+	c.Check(glob.Match("D"), NotNil)
+
+	// Unicode simple folding, where the cases have different lengths in UTF-8
+	glob, err = New("straße-k.txt", UnixStyle, false, CaseInsensitive(true))
+	c.Assert(err, IsNil)
+	match = glob.Match("STRAẞE-\u212a.TXT")
+	c.Assert(match, NotNil)
+	c.Check(match.Length(), Equals, len("STRAẞE-\u212a.TXT"))
+
+	glob, err = New("Σ*", UnixStyle, false, CaseInsensitive(true))
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("ς"), NotNil)
+	c.Check(glob.Match("σ"), NotNil)
+}
+
+func (s *MySuite) TestMatchCaseInsensitiveWindowsDefault(c *C) {
+	glob, err := New(`C:\Users\*\*.JPG`, WindowsStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match(`c:\users\Bob\photo.jpg`), NotNil)
+
+	glob, err = New(`C:\Users\*\*.JPG`, WindowsStyle, false, CaseInsensitive(false))
+	c.Assert(err, IsNil)
+	c.Check(glob.Match(`c:\users\Bob\photo.jpg`), IsNil)
+	c.Check(glob.Match(`C:\Users\Bob\photo.JPG`), NotNil)
+}
+
+func (s *MySuite) TestMatchCustomSeparators(c *C) {
+	// Dotted config keys
+	glob, err := NewWithOptions("server.*.port", Separators('.'))
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("server.web.port"), NotNil)
+	c.Check(glob.Match("server.web.tls.port"), IsNil)
+	c.Check(glob.Match("server.a/b.port"), NotNil)
+
+	glob, err = NewWithOptions("server.**.port", Separators('.'), Recursive(true))
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("server.web.tls.port"), NotNil)
+
+	// Several separators at once
+	glob, err = NewWithOptions("topic/*", Separators('/', ':'))
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("topic/orders"), NotNil)
+	c.Check(glob.Match("topic/orders:eu"), IsNil)
+
+	// No separators at all
+	glob, err = NewWithOptions("a*z", Separators())
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("a/b.c\\z"), NotNil)
+}
+
+func (s *MySuite) TestMatchWindowsSeparators(c *C) {
+	// Like Windows itself, both '\' and '/' are separators
+	glob, err := New(`C:\Users\*\AppData`, WindowsStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match(`C:\Users\bob\AppData`), NotNil)
+	c.Check(glob.Match(`C:\Users/bob/AppData`), NotNil)
+	c.Check(glob.Match(`C:/Users/bob/AppData`), NotNil)
+	c.Check(glob.Match(`C:\Users\bob/x\AppData`), IsNil)
+
+	glob, err = New(`src/*/*.go`, WindowsStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match(`src\pkg\main.go`), NotNil)
+	c.Check(glob.Match(`src\pkg/main.go`), NotNil)
+	c.Check(glob.Match(`src:pkg/main.go`), IsNil)
+	c.Check(glob.Match(`src/pkg/main.go`), NotNil)
+
+	glob, err = New(`a\**\*.go`, WindowsStyle, true)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match(`a\b/c\d.go`), NotNil)
+}
+
+func (s *MySuite) TestMatchProtectDotfiles(c *C) {
+	tests := []struct {
+		pattern string
+		matches []string
+		fails   []string
+	}{
+		{"*", []string{"visible", "a.b"}, []string{".git", ".env", "."}},
+		{".*", []string{".git", ".env"}, []string{"visible"}},
+		{"dir/*", []string{"dir/visible"}, []string{"dir/.env"}},
+		{"*/x", []string{"dir/x"}, []string{".git/x"}},
+		{"?git", []string{"xgit"}, []string{".git"}},
+		{"[.a-z]git", []string{"xgit"}, []string{".git"}},
+		{"a?b", []string{"a.b"}, []string{}},
+		{"{.env,*}", []string{".env", "x"}, []string{".git"}},
+		{"**/*.go", []string{"a/b/c.go", "a/b.go"}, []string{"a/.hidden/c.go", ".git/b.go", "a/.c.go"}},
+		{"src/**", []string{"src/a/b.go"}, []string{"src/a/.b.go", "src/.git/x"}},
+		{"src/**.go", []string{"src/a/b.go"}, []string{"src/.a/b.go"}},
+		{"!(*.tmp)", []string{"a.txt"}, []string{".txt"}},
+	}
+
+	for _, test := range tests {
+		glob, err := New(test.pattern, UnixStyle, true, ProtectDotfiles(true), ExtGlob(true))
+		c.Assert(err, IsNil, Commentf(test.pattern))
+
+		for _, haystack := range test.matches {
+			c.Check(glob.Match(haystack), NotNil, Commentf("%s %q", test.pattern, haystack))
+		}
+		for _, haystack := range test.fails {
+			c.Check(glob.Match(haystack), IsNil, Commentf("%s %q", test.pattern, haystack))
+		}
+	}
+
+	// Off by default
+	glob, err := New("*", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match(".git"), NotNil)
+}
+
+func (s *MySuite) TestMatchWildcardsPreferShortest(c *C) {
+	// Earlier wildcards match as little as they can; the last one, as much
+	glob, err := New("*-*", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	match := glob.Match("a-b-c")
+	c.Assert(match, NotNil)
+	c.Check(match.matchedStrings, DeepEquals, []string{"a", "-", "b-c"})
+}
+
+func (s *MySuite) TestStartsWithLongestLastAlternative(c *C) {
+	// The last token is greedy, whatever the order of its alternatives
+	glob, err := New("*.{c,cc}", UnixStyle, false)
+	c.Assert(err, IsNil)
+	match := glob.StartsWith("foo.cc")
+	c.Assert(match, NotNil)
+	c.Check(match.Length(), Equals, 6)
+	text, err := match.GetWildcardText(2)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "cc")
+
+	match = glob.StartsWith("foo.c/x")
+	c.Assert(match, NotNil)
+	c.Check(match.Length(), Equals, 5)
+
+	// Earlier alternations still take their first alternative
+	glob, err = New("{a,ab}*", UnixStyle, false)
+	c.Assert(err, IsNil)
+	match = glob.StartsWith("abc/d")
+	c.Assert(match, NotNil)
+	c.Check(match.matchedStrings, DeepEquals, []string{"a", "bc"})
+
+	glob, err = New("x@(a|ab|abc)", UnixStyle, false, ExtGlob(true))
+	c.Assert(err, IsNil)
+	match = glob.StartsWith("xabcd")
+	c.Assert(match, NotNil)
+	c.Check(match.Length(), Equals, 4)
+
+	glob, err = New("{1..120}", UnixStyle, false)
+	c.Assert(err, IsNil)
+	match = glob.StartsWith("1209")
+	c.Assert(match, NotNil)
+	c.Check(match.Length(), Equals, 3)
+
+	// Find extends the last token the same way
+	glob, err = New("=*.{c,cc}", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.FindIndex("x =a.cc y"), DeepEquals, []int{2, 7})
+}
+
+func (s *MySuite) TestMatchNumericRangeAfterWildcard(c *C) {
+	// The range can start at any of the digits
+	glob, err := New("*{1..5}", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	match := glob.Match("x123")
+	c.Assert(match, NotNil)
+	c.Check(match.matchedStrings, DeepEquals, []string{"x12", "3"})
+
+	c.Check(glob.Match("x1236"), IsNil)
+}
+
+func (s *MySuite) TestMatchManyWildcardsLongHaystack(c *C) {
+	// Backtracking would try every way of splitting the haystack
+	glob, err := New("*a*a*a*a*a*a*a*a*b", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	haystack := strings.Repeat("a", 10000)
+	c.Check(glob.Match(haystack), IsNil)
+	c.Check(glob.Match(haystack+"b"), NotNil)
+
+	glob, err = New("**/**/**/*_test.go", UnixStyle, true)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match(strings.Repeat("dir/", 2000)+"x_test.go"), NotNil)
+	c.Check(glob.Match(strings.Repeat("dir/", 2000)+"x_test.c"), IsNil)
+}
+
+func (s *MySuite) TestMatchConcurrently(c *C) {
+	glob, err := New("src/**/*.{go,c}", UnixStyle, true)
+	c.Assert(err, IsNil)
+
+	var wg sync.WaitGroup
+	results := make([]*Match, 16)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = glob.Match("src/a/b/file.go")
+		}(i)
+	}
+	wg.Wait()
+
+	for _, match := range results {
+		c.Assert(match, NotNil)
+		c.Check(match.matchedStrings, DeepEquals, []string{"src/", "a/b", "/", "file", ".", "go"})
+	}
+}
+
+func (s *MySuite) TestMatchDoesNotLeakGoroutines(c *C) {
+	patterns := []string{"**/*_test.go", "*/*.c", "a*b*c*d", "{x,y*}/**/z", "!(*.tmp)/+(a|b)*", "{1..20}*"}
+	haystacks := []string{"a/b/c_test.go", "a/b/c.go", "x/y.c", "abcd", "abdc", "y1/z", "q.tmp/ab", "7zz", ""}
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 200; i++ {
+		for _, pattern := range patterns {
+			glob, err := New(pattern, UnixStyle, true, ExtGlob(true))
+			c.Assert(err, IsNil)
+			for _, haystack := range haystacks {
+				glob.Match(haystack)
+				glob.StartsWith(haystack)
+			}
+		}
+	}
+
+	// Give any stray goroutines a chance to be counted
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Check(runtime.NumGoroutine() <= before, Equals, true,
+		Commentf("%d goroutines before, %d after", before, runtime.NumGoroutine()))
+}
+
+func (s *MySuite) TestMatchMultiByteRunes(c *C) {
+	tests := []struct {
+		pattern  string
+		haystack string
+		// The text of each wildcard, or nil when the match fails
+		wildcards []string
+	}{
+		// Japanese
+		{"?.png", "猫.png", []string{"猫"}},
+		{"??.png", "猫犬.png", []string{"猫", "犬"}},
+		{"?.png", "猫犬.png", nil},
+		{"[猫犬].png", "犬.png", []string{"犬"}},
+		{"[ぁ-ゟ]*", "ひらがな", []string{"ひ", "らがな"}},
+		{"[^a-z]*", "日本語/x", nil},
+		{"画像/*/?.jpg", "画像/風景/山.jpg", []string{"風景", "山"}},
+		// Emoji, each one a four byte rune
+		{"?", "🐱", []string{"🐱"}},
+		{"?-?", "🐱-🐶", []string{"🐱", "🐶"}},
+		{"[🐱🐶]", "🐶", []string{"🐶"}},
+		{"*🐱", "xx🐱", []string{"xx"}},
+		// A flag is two runes
+		{"?", "🇯🇵", nil},
+		{"??", "🇯🇵", []string{"🇯", "🇵"}},
+		// Greek, Cyrillic, Arabic, Hangul
+		{"[[:Greek:]]?", "λά", []string{"λ", "ά"}},
+		{"ф?йл.*", "файл.txt", []string{"а", "txt"}},
+		{"?ملف", "xملف", []string{"x"}},
+		{"[가-힣]?", "한글", []string{"한", "글"}},
+		// A combining accent is a rune of its own
+		{"e?", "e\u0301", []string{"\u0301"}},
+		{"?", "e\u0301", nil},
+	}
+
+	for _, test := range tests {
+		glob, err := New(test.pattern, UnixStyle, false)
+		c.Assert(err, IsNil, Commentf(test.pattern))
+
+		match := glob.Match(test.haystack)
+		if test.wildcards == nil {
+			c.Check(match, IsNil, Commentf("%s %q", test.pattern, test.haystack))
+			continue
+		}
+		c.Assert(match, NotNil, Commentf("%s %q", test.pattern, test.haystack))
+		for i, expected := range test.wildcards {
+			text, err := match.GetWildcardText(i + 1)
+			c.Check(err, IsNil)
+			c.Check(text, Equals, expected, Commentf("%s %q", test.pattern, test.haystack))
+		}
+	}
+}
+
+func (s *MySuite) TestMatchInvalidUTF8(c *C) {
+	// Each invalid byte is a character of its own
+	tests := []struct {
+		pattern  string
+		haystack string
+		// The text of each wildcard, or nil when the match fails
+		wildcards []string
+	}{
+		{"?", "\xff", []string{"\xff"}},
+		{"??", "\xe6\x97", []string{"\xe6", "\x97"}},
+		{"?", "\xe6\x97", nil},
+		{"*.txt", "\xffab\xfe.txt", []string{"\xffab\xfe"}},
+		{"a/**", "a/\xff/\xfe", []string{"\xff/\xfe"}},
+		{"[^a]", "\xff", []string{"\xff"}},
+		{"[^a]?", "\xe6\x97", []string{"\xe6", "\x97"}},
+		// Sets and text don't match a byte with their members' runes
+		{"[\ufffd]", "\xff", nil},
+		{"\ufffd", "\xff", nil},
+		{"?", "\ufffd", []string{"\ufffd"}},
+		// Text in the pattern matches the same bytes
+		{"\xffx*", "\xffxy", []string{"y"}},
+		{"\xffx*", "\xfexy", nil},
+	}
+
+	for _, test := range tests {
+		glob, err := New(test.pattern, UnixStyle, true)
+		c.Assert(err, IsNil, Commentf("%q", test.pattern))
+
+		match := glob.Match(test.haystack)
+		if test.wildcards == nil {
+			c.Check(match, IsNil, Commentf("%q %q", test.pattern, test.haystack))
+			continue
+		}
+		c.Assert(match, NotNil, Commentf("%q %q", test.pattern, test.haystack))
+		for i, expected := range test.wildcards {
+			text, err := match.GetWildcardText(i + 1)
+			c.Check(err, IsNil)
+			c.Check(text, Equals, expected, Commentf("%q %q", test.pattern, test.haystack))
+		}
+	}
+
+	// Case folding doesn't change how bytes are matched
+	glob, err := New("\xff.TXT", WindowsStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("\xff.txt"), NotNil)
+	c.Check(glob.Match("\ufffd.txt"), IsNil)
+
+	// A backslash escapes a whole byte
+	glob, err = New("\\\xff*", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("\xffx"), NotNil)
+}
+
+func (s *MySuite) TestMatchSingleCharsSkipSeparators(c *C) {
+	tests := []struct {
+		pattern string
+		matches []string
+		fails   []string
+	}{
+		{"foo?bar", []string{"foo.bar", "foo bar"}, []string{"foo/bar"}},
+		{"a[^a-z]b", []string{"a.b", "aXb"}, []string{"a/b"}},
+		{"a[[:punct:]]b", []string{"a.b"}, []string{"a/b"}},
+		{"a[/.]b", []string{"a.b"}, []string{"a/b"}},
+		// One member is plain text
+		{"a[/]b", []string{"a/b"}, []string{"a.b"}},
+		{"*/?", []string{"x/y"}, []string{"x//"}},
+	}
+
+	for _, test := range tests {
+		glob, err := New(test.pattern, UnixStyle, false)
+		c.Assert(err, IsNil, Commentf(test.pattern))
+
+		for _, haystack := range test.matches {
+			c.Check(glob.Match(haystack), NotNil, Commentf("%s %q", test.pattern, haystack))
+		}
+		for _, haystack := range test.fails {
+			c.Check(glob.Match(haystack), IsNil, Commentf("%s %q", test.pattern, haystack))
+		}
+	}
+
+	// Every separator counts
+	glob, err := New("a?b", WindowsStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match(`a\b`), IsNil)
+	c.Check(glob.Match("a/b"), IsNil)
+
+	// The option allows them
+	glob, err = New("foo?bar[^a-z]", UnixStyle, false, MatchSeparators(true))
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("foo/bar/"), NotNil)
+
+	glob, err = NewWithOptions("a.?", Separators('.'), MatchSeparators(true))
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("a.."), NotNil)
+}
+
+func (s *MySuite) TestMatchConformsToPathMatch(c *C) {
+	patterns := []string{
+		"abc", "a?c", "a*", "*c", "a*c", "*", "?", "??", "a/*", "*/c", "*/*", "a/?", "?/?",
+		"[abc]", "[a-c]*", "*[b-d]", "a[^b]c", "[^a-c]*", "a\\*", "a\\?c", "\\[a]",
+		"*.go", "*_test.go", "dir/*.go", "*/*_test.go", "日*", "?本", "[日月]本",
+	}
+	haystacks := []string{
+		"", "a", "c", "abc", "aXc", "ac", "a/c", "a/b/c", "a*", "a?c", "[a]", "a/",
+		"x.go", "x_test.go", "dir/x.go", "dir/x_test.go", "dir/sub/x.go", ".go",
+		"日本", "月本", "日/本",
+	}
+
+	for _, pattern := range patterns {
+		glob, err := New(pattern, UnixStyle, false)
+		c.Assert(err, IsNil, Commentf(pattern))
+
+		for _, haystack := range haystacks {
+			// path.Match lets an inverted set match '/'; see below
+			if strings.Contains(pattern, "[^") && strings.Contains(haystack, "/") {
+				continue
+			}
+			expected, err := path.Match(pattern, haystack)
+			c.Assert(err, IsNil, Commentf(pattern))
+			c.Check(glob.Match(haystack) != nil, Equals, expected, Commentf("%s %q", pattern, haystack))
+		}
+	}
+
+	// Unlike path.Match, but like shells, a set doesn't match a separator
+	matched, _ := path.Match("a[^b]c", "a/c")
+	c.Check(matched, Equals, true)
+	glob, err := New("a[^b]c", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match("a/c"), IsNil)
+}
+
+func (s *MySuite) TestMatchNormalize(c *C) {
+	composed := "caf\u00e9"
+	decomposed := "cafe\u0301"
+
+	// Without normalization, the forms differ
+	glob, err := New(composed+"/*", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match(decomposed+"/x"), IsNil)
+
+	for _, form := range []norm.Form{norm.NFC, norm.NFD} {
+		for _, pattern := range []string{composed + "/*", decomposed + "/*"} {
+			glob, err := New(pattern, UnixStyle, false, Normalize(form))
+			c.Assert(err, IsNil)
+
+			for _, dir := range []string{composed, decomposed} {
+				match := glob.Match(dir + "/menu.txt")
+				c.Assert(match, NotNil, Commentf("%v %q %q", form, pattern, dir))
+
+				// The text comes from the original string
+				c.Check(match.matchedStrings[0], Equals, dir+"/")
+				text, err := match.GetWildcardText(1)
+				c.Assert(err, IsNil)
+				c.Check(text, Equals, "menu.txt")
+			}
+		}
+	}
+
+	// A wildcard which matches a combined character gets the original runes
+	glob, err = New("caf?/*", UnixStyle, false, Normalize(norm.NFC))
+	c.Assert(err, IsNil)
+	match := glob.Match(decomposed + "/x")
+	c.Assert(match, NotNil)
+	text, err := match.GetWildcardText(1)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "e\u0301")
+
+	// Length is measured in the original string
+	glob, err = New(composed, UnixStyle, false, Normalize(norm.NFC))
+	c.Assert(err, IsNil)
+	match = glob.StartsWith(decomposed + "/x")
+	c.Assert(match, NotNil)
+	c.Check(match.Length(), Equals, len(decomposed))
+
+	// A capture which ends inside of a split character moves to its start
+	glob, err = New("cafe*", UnixStyle, false, Normalize(norm.NFD))
+	c.Assert(err, IsNil)
+	match = glob.Match(composed + "s")
+	c.Assert(match, NotNil)
+	c.Check(match.matchedStrings, DeepEquals, []string{"caf", "\u00e9s"})
+}
+
+func (s *MySuite) TestMatchWindowsVolumes(c *C) {
+	tests := []struct {
+		pattern string
+		matches []string
+		fails   []string
+	}{
+		{`C:\Users\*\AppData`,
+			[]string{`C:\Users\me\AppData`, `c:\users\me\appdata`, `\\?\C:\Users\me\AppData`},
+			[]string{`D:\Users\me\AppData`, `Users\me\AppData`, `\\server\C\Users\me\AppData`}},
+		{`\\server\share\**`,
+			[]string{`\\server\share\a\b.txt`, `\\SERVER\Share\x`, `\\?\UNC\server\share\x`, `//server/share\x`},
+			[]string{`\\server\other\x`, `\\other\share\x`, `\server\share\x`, `C:\server\share\x`}},
+		{`\\?\C:\*`,
+			[]string{`C:\x`, `\\?\c:\x`, `\\.\C:\x`},
+			[]string{`D:\x`}},
+		{`\\.\pipe\*`,
+			[]string{`\\.\PIPE\x`},
+			[]string{`\\?\pipe\x`, `pipe\x`}},
+		// Wildcards can't match the volume
+		{`*\Users`,
+			[]string{`home\Users`},
+			[]string{`C:\Users`, `\\server\share\Users`}},
+		{`*:\Users`,
+			[]string{`ab:\Users`},
+			[]string{`C:\Users`}},
+		{`**\x`,
+			[]string{`a\b\x`, `\x`},
+			[]string{`C:\a\x`, `\\server\share\x`}},
+		{`C:*`,
+			[]string{`c:file`, `C:`},
+			[]string{`C:\file`}},
+	}
+
+	for _, test := range tests {
+		glob, err := New(test.pattern, WindowsStyle, true)
+		c.Assert(err, IsNil, Commentf(test.pattern))
+
+		for _, haystack := range test.matches {
+			c.Check(glob.Match(haystack), NotNil, Commentf("%s %s", test.pattern, haystack))
+		}
+		for _, haystack := range test.fails {
+			c.Check(glob.Match(haystack), IsNil, Commentf("%s %s", test.pattern, haystack))
+		}
+	}
+
+	// Wildcards after the volume are counted as usual
+	glob, err := New(`\\server\share\*\*.txt`, WindowsStyle, false)
+	c.Assert(err, IsNil)
+	match := glob.Match(`\\?\UNC\Server\Share\docs\a.txt`)
+	c.Assert(match, NotNil)
+	text, err := match.GetWildcardText(1)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "docs")
+	c.Check(match.Length(), Equals, len(`\\?\UNC\Server\Share\docs\a.txt`))
+
+	glob, err = New(`C:`, WindowsStyle, false)
+	c.Assert(err, IsNil)
+	match = glob.StartsWith(`c:\x`)
+	c.Assert(match, NotNil)
+	c.Check(match.Length(), Equals, 2)
+
+	// Errors give positions within the whole pattern
+	_, err = New(`C:\[x`, WindowsStyle, false)
+	c.Assert(err, NotNil)
+	c.Check(err.Error(), Equals, "Opening bracket at position 4 not terminated")
+
+	// Other styles have no volumes
+	glob, err = New(`C:/*`, UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match(`c:/x`), IsNil)
+	glob, err = New(`*/x`, UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match(`C:/x`), NotNil)
+}
+
+func (s *MySuite) TestMatchWildcardSpan(c *C) {
+	glob, err := New("src/*/*.go", UnixStyle, false)
+	c.Assert(err, IsNil)
+	haystack := "src/pkg/main.go"
+	match := glob.Match(haystack)
+	c.Assert(match, NotNil)
+
+	start, end, err := match.WildcardSpan(1)
+	c.Assert(err, IsNil)
+	c.Check(haystack[start:end], Equals, "pkg")
+	start, end, err = match.WildcardSpan(2)
+	c.Assert(err, IsNil)
+	c.Check([]int{start, end}, DeepEquals, []int{8, 12})
+	c.Check(match.WildcardSpans(), DeepEquals, [][2]int{{4, 7}, {8, 12}})
+
+	_, _, err = match.WildcardSpan(0)
+	c.Check(err, NotNil)
+	_, _, err = match.WildcardSpan(3)
+	c.Check(err, NotNil)
+
+	// With StartsWith, and wildcards inside of braces
+	glob, err = New("{a,b}*/", UnixStyle, false)
+	c.Assert(err, IsNil)
+	match = glob.StartsWith("bxy/z")
+	c.Assert(match, NotNil)
+	c.Check(match.WildcardSpans(), DeepEquals, [][2]int{{0, 1}, {1, 3}})
+
+	// Case folding doesn't move the offsets
+	glob, err = New("straße/*", UnixStyle, false, CaseInsensitive(true))
+	c.Assert(err, IsNil)
+	haystack = "STRAẞE/x"
+	match = glob.Match(haystack)
+	c.Assert(match, NotNil)
+	start, end, err = match.WildcardSpan(1)
+	c.Assert(err, IsNil)
+	c.Check(haystack[start:end], Equals, "x")
+
+	// The offsets are in the original haystack, not the normalized one
+	glob, err = New("caf\u00e9/*/?", UnixStyle, false, Normalize(norm.NFC))
+	c.Assert(err, IsNil)
+	haystack = "cafe\u0301/cre\u0300me/e\u0301"
+	match = glob.Match(haystack)
+	c.Assert(match, NotNil)
+	c.Check(match.WildcardSpans(), DeepEquals, [][2]int{{7, 14}, {15, 18}})
+	start, end, err = match.WildcardSpan(1)
+	c.Assert(err, IsNil)
+	c.Check(haystack[start:end], Equals, "cre\u0300me")
+
+	// And after a Windows volume
+	glob, err = New(`C:\*`, WindowsStyle, false)
+	c.Assert(err, IsNil)
+	match = glob.Match(`\\?\c:\dir`)
+	c.Assert(match, NotNil)
+	c.Check(match.WildcardSpans(), DeepEquals, [][2]int{{7, 10}})
+}
+
+func (s *MySuite) TestFind(c *C) {
+	glob, err := New("**/*.go:*", UnixStyle, true)
+	c.Assert(err, IsNil)
+
+	line := "error at src/pkg/main.go:12"
+	match := glob.Find(line)
+	c.Assert(match, NotNil)
+	c.Check(line[match.Start():match.End()], Equals, "error at src/pkg/main.go:12")
+	c.Check(match.Length(), Equals, len(line))
+
+	glob, err = New("*.go:{1..999}", UnixStyle, false)
+	c.Assert(err, IsNil)
+	line = "see main.go:12 and util.go:7, not x.go:0"
+	match = glob.Find(line)
+	c.Assert(match, NotNil)
+	c.Check(line[match.Start():match.End()], Equals, "see main.go:12")
+	c.Check(glob.FindIndex(line), DeepEquals, []int{0, 14})
+	text, err := match.GetWildcardText(2)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "12")
+	start, end, err := match.WildcardSpan(1)
+	c.Assert(err, IsNil)
+	c.Check(line[start:end], Equals, "see main")
+
+	glob, err = New("[a-z]*.go:[0-9]", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.FindIndex("  main.go:1"), DeepEquals, []int{2, 11})
+	c.Check(glob.FindIndex("main.go:x"), IsNil)
+	c.Check(glob.Find("main.go:x"), IsNil)
+
+	// The search starts after the volume
+	glob, err = New(`C:\*.txt`, WindowsStyle, false)
+	c.Assert(err, IsNil)
+	line = `copied to c:\notes.txt`
+	match = glob.Find(line)
+	c.Assert(match, NotNil)
+	c.Check(line[match.Start():match.End()], Equals, `c:\notes.txt`)
+	c.Check(glob.Find(`copied to D:\notes.txt`), IsNil)
+
+	// A volume only starts a path at the start, or after a separator or a
+	// rune which can't be in a name
+	glob, err = New(`C:\*`, WindowsStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Find(`see XC:\foo here`), IsNil)
+	c.Check(glob.FindIndex(`see C:\foo\bar`), DeepEquals, []int{4, 10})
+	c.Check(glob.FindIndex(`x\C:\foo`), DeepEquals, []int{2, 8})
+
+	// Wildcards don't match a volume, as with Match
+	glob, err = New(`*\x`, WindowsStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.Match(`C:\x`), IsNil)
+	c.Check(glob.FindIndex(`C:\x`), DeepEquals, []int{2, 4})
+	c.Check(glob.FindIndex(`a\x C:\x`), DeepEquals, []int{0, 3})
+	c.Check(glob.FindAll(`a\x C:\x`, -1), HasLen, 2)
+
+	// Offsets are in the original string
+	glob, err = New("caf\u00e9", UnixStyle, false, Normalize(norm.NFC))
+	c.Assert(err, IsNil)
+	line = "a cafe\u0301!"
+	match = glob.Find(line)
+	c.Assert(match, NotNil)
+	c.Check(line[match.Start():match.End()], Equals, "cafe\u0301")
+}
+
+func (s *MySuite) TestFindAll(c *C) {
+	glob, err := New("[a-z]*.go", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	line := "a.go, b/c.go and d.txt e.go"
+	var found []string
+	for _, match := range glob.FindAll(line, -1) {
+		found = append(found, line[match.Start():match.End()])
+	}
+	// * matches spaces, but not the separator
+	c.Check(found, DeepEquals, []string{"a.go", "c.go", "and d.txt e.go"})
+
+	c.Check(len(glob.FindAll(line, 2)), Equals, 2)
+
+	// The example in the README
+	glob, err = New("src/*.go", UnixStyle, false)
+	c.Assert(err, IsNil)
+	line = "built src/main.go and src/util.go"
+	found = nil
+	for _, match := range glob.FindAll(line, -1) {
+		found = append(found, line[match.Start():match.End()])
+	}
+	c.Check(found, DeepEquals, []string{"src/main.go", "src/util.go"})
+
+	// A leading wildcard takes in the text before it
+	glob, err = New("*.go", UnixStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.FindIndex("built src/main.go"), DeepEquals, []int{10, 17})
+	glob, err = New("**/*.go", UnixStyle, true)
+	c.Assert(err, IsNil)
+	c.Check(glob.FindIndex("built src/main.go"), DeepEquals, []int{0, 17})
+	c.Check(glob.FindAll("nothing", -1), IsNil)
+
+	// Empty matches don't overlap the matches before them
+	glob, err = New("{a,}", UnixStyle, false)
+	c.Assert(err, IsNil)
+	var spans [][]int
+	for _, match := range glob.FindAll("baab", -1) {
+		spans = append(spans, []int{match.Start(), match.End()})
+	}
+	c.Check(spans, DeepEquals, [][]int{{0, 0}, {1, 2}, {2, 3}, {4, 4}})
+}
+
+func (s *MySuite) TestEndsWith(c *C) {
+	glob, err := New("*/*.go", UnixStyle, false)
+	c.Assert(err, IsNil)
+
+	haystack := "/home/me/src/pkg/main.go"
+	match := glob.EndsWith(haystack)
+	c.Assert(match, NotNil)
+	c.Check(haystack[match.Start():], Equals, "pkg/main.go")
+	c.Check(match.End(), Equals, len(haystack))
+	text, err := match.GetWildcardText(1)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "pkg")
+
+	c.Check(glob.EndsWith("pkg/main.go.txt"), IsNil)
+
+	// Wildcards don't match a volume, as with Match
+	glob, err = New(`*\x`, WindowsStyle, false)
+	c.Assert(err, IsNil)
+	match = glob.EndsWith(`C:\x`)
+	c.Assert(match, NotNil)
+	c.Check(match.Start(), Equals, 2)
+	text, err = match.GetWildcardText(1)
+	c.Assert(err, IsNil)
+	c.Check(text, Equals, "")
+
+	glob, err = New(`C:\*`, WindowsStyle, false)
+	c.Assert(err, IsNil)
+	c.Check(glob.EndsWith(`see XC:\foo`), IsNil)
+	match = glob.EndsWith(`see C:\foo`)
+	c.Assert(match, NotNil)
+	c.Check(match.Start(), Equals, 4)
+
+	// The longest suffix wins
+	glob, err = New("**.go", UnixStyle, true)
+	c.Assert(err, IsNil)
+	match = glob.EndsWith("a/b.go")
+	c.Assert(match, NotNil)
+	c.Check(match.Start(), Equals, 0)
+
+	// Match and StartsWith start at 0
+	match = glob.Match("a/b.go")
+	c.Assert(match, NotNil)
+	c.Check([]int{match.Start(), match.End(), match.Length()}, DeepEquals, []int{0, 6, 6})
+}
@@ -0,0 +1,56 @@
+package globingo
+
+import (
+	"golang.org/x/text/unicode/norm"
+)
+
+// A string to match, converted to a normalization form
+type normalizedText struct {
+	normalized string
+	// The offset in the original string of each byte offset in the
+	// normalized string, and of its end. nil when they are the same.
+	offsets []int
+}
+
+// Normalizes the text a segment at a time, between normalization
+// boundaries, since each segment is normalized on its own. Offsets inside
+// of a segment which changed map to the start of the original segment.
+func newNormalizedText(original string, form *norm.Form) normalizedText {
+	if form == nil || form.IsNormalString(original) {
+		return normalizedText{normalized: original}
+	}
+
+	normalized := make([]byte, 0, len(original))
+	offsets := make([]int, 0, len(original)+1)
+	for start := 0; start < len(original); {
+		end := start + form.NextBoundaryInString(original[start:], true)
+		if end == start {
+			end = len(original)
+		}
+
+		segment := original[start:end]
+		if form.IsNormalString(segment) {
+			for i := 0; i < len(segment); i++ {
+				offsets = append(offsets, start+i)
+			}
+		} else {
+			segment = form.String(segment)
+			for i := 0; i < len(segment); i++ {
+				offsets = append(offsets, start)
+			}
+		}
+		normalized = append(normalized, segment...)
+		start = end
+	}
+	offsets = append(offsets, len(original))
+
+	return normalizedText{normalized: string(normalized), offsets: offsets}
+}
+
+// Returns the offset in the original string
+func (self normalizedText) originalOffset(pos int) int {
+	if self.offsets == nil {
+		return pos
+	}
+	return self.offsets[pos]
+}
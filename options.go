@@ -0,0 +1,156 @@
+package globingo
+
+import (
+	"golang.org/x/text/unicode/norm"
+)
+
+// An Option changes how a glob pattern is parsed or matched.
+// Options are given to NewWithOptions, or as the last arguments to New.
+type Option func(*options)
+
+type options struct {
+	style     PathStyle
+	recursive bool
+	// nil means to use the separators for the path style
+	separators separatorSet
+	extGlob    bool
+	// nil means to use the default for the path style
+	backslashEscapes *bool
+	caseInsensitive  *bool
+	protectDotfiles  bool
+	matchSeparators  bool
+	// nil means not to normalize
+	normalization *norm.Form
+	allErrors     bool
+	strictBraces  bool
+}
+
+// Returns the options after applying opts to the defaults
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Style sets which type of directory separator characters to expect:
+// NativeStyle, WindowsStyle, or UnixStyle. The default is NativeStyle.
+func Style(style PathStyle) Option {
+	return func(o *options) {
+		o.style = style
+	}
+}
+
+// Separators sets the runes which separate directories, instead of the
+// separators for the path style. This allows globs to match other kinds of
+// hierarchical names, like dotted config keys with Separators('.'), or
+// names with several separators. With no runes, nothing is a separator.
+func Separators(separators ...rune) Option {
+	return func(o *options) {
+		o.separators = append(separatorSet{}, separators...)
+	}
+}
+
+// Recursive allows '**' as a wildcard which matches any directory or
+// filename, to any depth. When false, '**' is not allowed in a glob
+// pattern. The default is false.
+func Recursive(enabled bool) Option {
+	return func(o *options) {
+		o.recursive = enabled
+	}
+}
+
+// ExtGlob enables the extended glob operators of bash (shopt -s extglob)
+// and ksh. Each takes a list of patterns separated by '|':
+//
+//	?(pattern-list) - zero or one of the patterns
+//	*(pattern-list) - zero or more of the patterns
+//	+(pattern-list) - one or more of the patterns
+//	@(pattern-list) - exactly one of the patterns
+//	!(pattern-list) - anything within a directory level, except one of the patterns
+//
+// Each operator counts as a single wildcard. The default is false.
+func ExtGlob(enabled bool) Option {
+	return func(o *options) {
+		o.extGlob = enabled
+	}
+}
+
+// BackslashEscapes makes '\' escape the character after it, so that
+// \*, \?, \[ and \\ match a literal '*', '?', '[' and '\', as in POSIX fnmatch.
+// This also works inside of brackets, like [\]]. The default is true unless
+// '\' is a directory separator, as it is for WindowsStyle.
+func BackslashEscapes(enabled bool) Option {
+	return func(o *options) {
+		o.backslashEscapes = &enabled
+	}
+}
+
+// CaseInsensitive makes the pattern match without regard to case, using
+// Unicode simple case folding, so *.JPG matches photo.jpg. This applies to
+// plain text and to the members of bracket expressions. The text which a
+// wildcard matched keeps the case of the string being matched. The default
+// is true for WindowsStyle, and false otherwise.
+func CaseInsensitive(enabled bool) Option {
+	return func(o *options) {
+		o.caseInsensitive = &enabled
+	}
+}
+
+// ProtectDotfiles stops wildcards from matching a '.' at the start of a
+// file or directory name, so that *, ?, [.a-z] and !(...) skip hidden names
+// like .git and .env, as they do in shells and filepath.Glob. The pattern has
+// to spell out the dot, like .* or {.env,*}. '**' doesn't match hidden
+// directories either. The default is false.
+func ProtectDotfiles(enabled bool) Option {
+	return func(o *options) {
+		o.protectDotfiles = enabled
+	}
+}
+
+// MatchSeparators lets '?' and bracket expressions match a directory
+// separator, for patterns which aren't paths. Otherwise, as in shells and
+// filepath.Match, foo?bar doesn't match foo/bar, and no set, not even
+// [^a-z], matches a separator. A set with one member, like [/], is plain
+// text, so it still matches. The default is false.
+func MatchSeparators(enabled bool) Option {
+	return func(o *options) {
+		o.matchSeparators = enabled
+	}
+}
+
+// Normalize converts the pattern's text, and each string being matched, to
+// a Unicode normalization form, such as norm.NFC, before matching. Then
+// café/* matches a path whether its é is one code point or an e followed by
+// a combining accent, as on macOS. The text which a wildcard matched is
+// taken from the original string. When normalization splits or combines a
+// character, and a wildcard's text starts or ends inside of it, the position
+// moves to the start of the original character. ? and the members of sets
+// are single code points of the normalized text, so a decomposed form like
+// norm.NFD is best used without them. The default is not to normalize.
+func Normalize(form norm.Form) Option {
+	return func(o *options) {
+		o.normalization = &form
+	}
+}
+
+// AllErrors makes New, NewWithOptions and Parse carry on past a syntax
+// error, to find all of the errors in the pattern. They are returned
+// together as SyntaxErrors, in the order of their offsets. The default is
+// false, which returns the first error as a *SyntaxError.
+func AllErrors(enabled bool) Option {
+	return func(o *options) {
+		o.allErrors = enabled
+	}
+}
+
+// StrictBraces makes a '{' without a matching '}' a syntax error. By
+// default, as in shells, such a '{' is plain text. Either way, braces
+// without a comma, like {a}, are plain text, unless they are a numeric
+// range or a named wildcard.
+func StrictBraces(enabled bool) Option {
+	return func(o *options) {
+		o.strictBraces = enabled
+	}
+}
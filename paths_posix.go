@@ -3,5 +3,5 @@
 package globingo
 
 const (
-	kNativeDirectorySeparator = '/'
+	kNativeStyle = UnixStyle
 )
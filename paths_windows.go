@@ -1,5 +1,5 @@
 package globingo
 
 const (
-	kNativeDirectorySeparator = '\\'
+	kNativeStyle = WindowsStyle
 )
@@ -0,0 +1,348 @@
+package globingo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A Replacement is a compiled replacement template, which builds new
+// strings from the text that a Glob's wildcards matched. It is safe for
+// concurrent use.
+type Replacement struct {
+	template string
+	parts    []templatePart
+}
+
+type templateOp int
+
+const (
+	// Literal text
+	kPartText templateOp = iota
+	// The text of a wildcard
+	kPartWildcard
+	// \U, \L or \E, which changes the case of what follows
+	kPartCase
+)
+
+type caseChange int
+
+const (
+	kCaseUnchanged caseChange = iota
+	kCaseUpper
+	kCaseLower
+)
+
+// A piece of a replacement template
+type templatePart struct {
+	op   templateOp
+	text string
+	// The wildcard, by number, or when name is set, by name
+	wildcard int
+	name     string
+	// Used when the wildcard matched the empty string
+	defaultText string
+	// When non-zero, a number which the wildcard matched is zero-padded
+	// to this width
+	width      int
+	caseChange caseChange
+}
+
+const (
+	kAnything      = 1
+	kFirstEscape   = 2
+	kNumericEscape = 3
+	kDollar        = 4
+	kReference     = 5
+)
+
+// Compiles a replacement template for the wildcards of the glob. Unknown
+// wildcard numbers and names are reported here, once, rather than by each
+// Apply. The template has these references:
+//
+//	\N or ${N}       the text of the Nth wildcard
+//	${name}          the text of the wildcard named by {name:pattern}
+//	${N:-text}       the text of the wildcard, or text when it matched nothing
+//	${N:0W}          a number which the wildcard matched, zero-padded to W digits
+//	\U, \L           change what follows to upper or lower case, until \E
+//	\\ and \$        a literal '\' and '$'
+//
+// Names can be used wherever N can.
+func CompileReplacement(template string, glob *Glob) (*Replacement, error) {
+	return compileReplacement(template, func(part *templatePart) (int, error) {
+		if part.name != "" {
+			n, ok := glob.wildcardNames[part.name]
+			if !ok {
+				return 0, errors.Errorf("The replacement refers to the wildcard %q, but the pattern has no wildcard with that name",
+					part.name)
+			}
+			return n, nil
+		}
+		if part.wildcard < 1 || part.wildcard > glob.NumWildcards() {
+			return 0, errors.Errorf("The replacement refers to wildcard #%d, but the pattern has %d wildcards",
+				part.wildcard, glob.NumWildcards())
+		}
+		return part.wildcard, nil
+	})
+}
+
+// Checks that a replacement template for Match.Replace is valid, and that
+// it only refers to wildcards which the pattern has, by number or name.
+// Replace would fail on every match otherwise.
+func (self *Glob) CheckReplacement(template string) error {
+	_, err := CompileReplacement(template, self)
+	return err
+}
+
+// Parses the template, and numbers each reference with resolve, which
+// returns an error for a reference to a wildcard that doesn't exist
+func compileReplacement(template string, resolve func(*templatePart) (int, error)) (*Replacement, error) {
+	parts, err := parseTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+	for i := range parts {
+		if parts[i].op != kPartWildcard {
+			continue
+		}
+		n, err := resolve(&parts[i])
+		if err != nil {
+			return nil, err
+		}
+		parts[i].wildcard = n
+	}
+	return &Replacement{template: template, parts: parts}, nil
+}
+
+// Returns the template that the Replacement was compiled from
+func (self *Replacement) String() string {
+	return self.template
+}
+
+// Returns the template with its references replaced by the text that the
+// match's wildcards matched. The match must come from the Glob which the
+// Replacement was compiled for.
+func (self *Replacement) Apply(match *Match) string {
+	var result strings.Builder
+	textCase := kCaseUnchanged
+
+	for i := range self.parts {
+		part := &self.parts[i]
+		var text string
+		switch part.op {
+		case kPartText:
+			text = part.text
+		case kPartWildcard:
+			if part.wildcard <= len(match.wildcardPositions) {
+				text = match.matchedStrings[match.wildcardPositions[part.wildcard-1]]
+			}
+			if text == "" {
+				text = part.defaultText
+			} else if part.width > 0 {
+				text = zeroPad(text, part.width)
+			}
+		case kPartCase:
+			textCase = part.caseChange
+			continue
+		}
+
+		switch textCase {
+		case kCaseUpper:
+			text = strings.ToUpper(text)
+		case kCaseLower:
+			text = strings.ToLower(text)
+		}
+		result.WriteString(text)
+	}
+	return result.String()
+}
+
+// Pads a decimal integer, which may be negative, with zeros, so that it is
+// at least width characters long. Other text is returned unchanged.
+func zeroPad(text string, width int) string {
+	digits := strings.TrimPrefix(text, "-")
+	if digits == "" || strings.Trim(digits, "0123456789") != "" || len(text) >= width {
+		return text
+	}
+	return text[:len(text)-len(digits)] + strings.Repeat("0", width-len(text)) + digits
+}
+
+// Parses a replacement template into parts; see CompileReplacement
+func parseTemplate(template string) ([]templatePart, error) {
+	var parts []templatePart
+	var text string
+	var state int = kAnything
+	var referenceText string
+	var referencePos int
+
+	addPart := func(part templatePart) {
+		if text != "" {
+			parts = append(parts, templatePart{op: kPartText, text: text})
+			text = ""
+		}
+		parts = append(parts, part)
+	}
+	addNumericReference := func() error {
+		n, err := strconv.Atoi(referenceText)
+		if err != nil {
+			return errors.Errorf("The wildcard number %s is too large", referenceText)
+		}
+		addPart(templatePart{op: kPartWildcard, wildcard: n})
+		return nil
+	}
+
+	for i, runeValue := range template {
+		switch state {
+		case kAnything:
+			if runeValue == '\\' {
+				state = kFirstEscape
+				referenceText = ""
+			} else if runeValue == '$' {
+				state = kDollar
+			} else {
+				text += string(runeValue)
+			}
+
+		case kFirstEscape:
+			if '0' <= runeValue && runeValue <= '9' {
+				referenceText += string(runeValue)
+				state = kNumericEscape
+			} else if runeValue == '\\' || runeValue == '$' {
+				text += string(runeValue)
+				state = kAnything
+			} else if change, ok := caseEscapes[runeValue]; ok {
+				addPart(templatePart{op: kPartCase, caseChange: change})
+				state = kAnything
+			} else {
+				return nil, errors.Errorf("\\ should be followed by number or \\, not %s at position %d",
+					string(runeValue), i+1)
+			}
+
+		case kNumericEscape:
+			if '0' <= runeValue && runeValue <= '9' {
+				referenceText += string(runeValue)
+			} else {
+				if err := addNumericReference(); err != nil {
+					return nil, err
+				}
+				if runeValue == '\\' {
+					state = kFirstEscape
+					referenceText = ""
+				} else if runeValue == '$' {
+					state = kDollar
+				} else {
+					text += string(runeValue)
+					state = kAnything
+				}
+			}
+
+		case kDollar:
+			// Only ${ starts a reference
+			if runeValue == '{' {
+				state = kReference
+				referenceText = ""
+				referencePos = i
+			} else if runeValue == '\\' {
+				text += "$"
+				state = kFirstEscape
+				referenceText = ""
+			} else if runeValue != '$' {
+				text += "$" + string(runeValue)
+				state = kAnything
+			} else {
+				text += "$"
+			}
+
+		case kReference:
+			if runeValue != '}' {
+				referenceText += string(runeValue)
+				break
+			}
+			part, err := parseReference(referenceText, referencePos)
+			if err != nil {
+				return nil, err
+			}
+			addPart(part)
+			state = kAnything
+
+		default:
+			panic(fmt.Sprintf("state = %d", state))
+		}
+	}
+
+	// Reached the end of the string. Was the \\ pattern at the end of the string?
+	switch state {
+	case kAnything:
+		// nothing to do
+
+	case kFirstEscape:
+		return nil, errors.Errorf("\\ should be followed by number or \\ at the end of the string")
+
+	case kNumericEscape:
+		if err := addNumericReference(); err != nil {
+			return nil, err
+		}
+
+	case kDollar:
+		text += "$"
+
+	case kReference:
+		return nil, errors.Errorf("${ at position %d is not terminated", referencePos)
+
+	default:
+		panic(fmt.Sprintf("state = %d", state))
+	}
+
+	if text != "" {
+		parts = append(parts, templatePart{op: kPartText, text: text})
+	}
+	return parts, nil
+}
+
+var caseEscapes = map[rune]caseChange{
+	'U': kCaseUpper,
+	'L': kCaseLower,
+	'E': kCaseUnchanged,
+}
+
+// Parses the text between ${ and }: a number or a name, which can be
+// followed by :-default or :0width
+func parseReference(referenceText string, position int) (templatePart, error) {
+	part := templatePart{op: kPartWildcard}
+
+	reference := referenceText
+	if colon := strings.IndexByte(referenceText, ':'); colon >= 0 {
+		reference = referenceText[:colon]
+		modifier := referenceText[colon+1:]
+		switch {
+		case strings.HasPrefix(modifier, "-"):
+			part.defaultText = modifier[1:]
+		case strings.HasPrefix(modifier, "0") && len(modifier) > 1 && strings.Trim(modifier, "0123456789") == "":
+			width, err := strconv.Atoi(modifier)
+			if err != nil {
+				return part, errors.Errorf("The width %s in ${%s} at position %d is too large",
+					modifier, referenceText, position)
+			}
+			part.width = width
+		default:
+			return part, errors.Errorf("${%s} at position %d should be followed by :-default or :0width, not :%s",
+				reference, position, modifier)
+		}
+	}
+
+	if reference != "" && strings.Trim(reference, "0123456789") == "" {
+		n, err := strconv.Atoi(reference)
+		if err != nil {
+			return part, errors.Errorf("The wildcard number %s is too large", reference)
+		}
+		part.wildcard = n
+	} else if isWildcardName(reference) {
+		part.name = reference
+	} else {
+		return part, errors.Errorf("${%s} at position %d should contain a number or a wildcard name",
+			referenceText, position)
+	}
+	return part, nil
+}
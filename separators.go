@@ -0,0 +1,38 @@
+package globingo
+
+import (
+	"github.com/pkg/errors"
+)
+
+// The runes which separate directories (or whatever the levels of a
+// haystack are, like the parts of a dotted config key).
+type separatorSet []rune
+
+func (self separatorSet) contains(r rune) bool {
+	for _, separator := range self {
+		if r == separator {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the directory separators for the style. Like Windows itself,
+// WindowsStyle accepts '/' as well as '\'.
+func styleSeparators(style PathStyle) (separatorSet, error) {
+	switch style {
+	case NativeStyle:
+		return styleSeparators(kNativeStyle)
+	case UnixStyle:
+		return separatorSet{kUnixStyle}, nil
+	case WindowsStyle:
+		return separatorSet{kWindowsStyle, kUnixStyle}, nil
+	default:
+		return nil, errors.Errorf("Unexpected path style %d", style)
+	}
+}
+
+// Is it WindowsStyle, or NativeStyle on Windows?
+func isWindowsStyle(style PathStyle) bool {
+	return style == WindowsStyle || (style == NativeStyle && kNativeStyle == WindowsStyle)
+}
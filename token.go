@@ -1,9 +1,10 @@
 package globingo
 
 import (
-	"context"
 	"fmt"
+	"strconv"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -12,28 +13,42 @@ type tokenType int
 const (
 	kTokenPlainText tokenType = iota
 	kTokenSingleChar
-	kTokenRange
+	kTokenCharSet
 	kTokenMultiCharSingleDirectory
 	kTokenMultiCharMultiDirectory
+	kTokenAlternation
+	kTokenNumericRange
+	kTokenExtGlob
 )
 
 // Note: can lowercase these functions
 type tokenInterface interface {
-	Matches(string, int, rune) (bool, string)
 	Type() tokenType
 	IsWildcard() bool
-	CanHaveMultipleAnswers() bool
-	CanMatchZeroCharacters() bool
 	String() string
-	// Only for recursive tokens
-	AllMatchedPatterns(ctx context.Context, haystack string, start int, directorySeparator rune) chan string
+	// Adds the instructions which match the token. When greedy, the
+	// token prefers to match as much as it can.
+	compile(c *compiler, greedy bool)
+	span() *tokenSpan
+}
+
+// The position of a token's text within the whole pattern, in bytes
+type tokenSpan struct {
+	start int
+	end   int
+}
+
+func (self *tokenSpan) span() *tokenSpan {
+	return self
 }
 
 // ============================================================================
 // Match a specific string
 // ============================================================================
 type tokenPlainText struct {
-	text string
+	tokenSpan
+	text     string
+	foldCase bool
 }
 
 func (self *tokenPlainText) String() string {
@@ -48,34 +63,44 @@ func (self *tokenPlainText) IsWildcard() bool {
 	return false
 }
 
-func (self *tokenPlainText) CanHaveMultipleAnswers() bool {
-	return false
-}
-
-func (self *tokenPlainText) CanMatchZeroCharacters() bool {
-	return false
-}
-
-func (self *tokenPlainText) AllMatchedPatterns(ctx context.Context, haystack string, start int, directorySeparator rune) chan string {
-	panic("should not be called")
-	return nil
-}
+func (self *tokenPlainText) compile(c *compiler, greedy bool) {
+	for pos := 0; pos < len(self.text); {
+		r, w := utf8.DecodeRuneInString(self.text[pos:])
+		literal := self.text[pos : pos+w]
+		pos += w
 
-func (self *tokenPlainText) Matches(haystack string, start int, directorySeparator rune) (bool, string) {
-	if len(haystack) < start+len(self.text) {
-		return false, ""
-	}
+		// A separator matches any of the separators, so that a Windows
+		// pattern's '\' matches '/'
+		if c.separators.contains(r) {
+			separators := c.separators
+			c.consume(func(haystack string, hpos int, hr rune, hw int) bool {
+				return separators.contains(hr)
+			})
+			continue
+		}
 
-	if haystack[start:start+len(self.text)] == self.text {
-		return true, self.text
+		// An invalid byte only matches the same byte
+		if self.foldCase && !(r == utf8.RuneError && w == 1) {
+			// The haystack's rune can have a different length than ours
+			c.consume(func(haystack string, hpos int, hr rune, hw int) bool {
+				return equalFoldRune(r, hr)
+			})
+		} else {
+			c.consume(func(haystack string, hpos int, hr rune, hw int) bool {
+				return haystack[hpos:hpos+hw] == literal
+			})
+		}
 	}
-	return false, ""
 }
 
 // ============================================================================
 // Match any single character
 // ============================================================================
-type tokenSingleChar struct{}
+type tokenSingleChar struct {
+	tokenSpan
+	protectDotfiles bool
+	matchSeparators bool
+}
 
 func (self *tokenSingleChar) Type() tokenType {
 	return kTokenSingleChar
@@ -89,93 +114,113 @@ func (self *tokenSingleChar) IsWildcard() bool {
 	return true
 }
 
-func (self *tokenSingleChar) CanHaveMultipleAnswers() bool {
-	return false
-}
-
-func (self *tokenSingleChar) CanMatchZeroCharacters() bool {
-	return false
-}
-
-func (self *tokenSingleChar) AllMatchedPatterns(ctx context.Context, haystack string, start int, directorySeparator rune) chan string {
-	panic("should not be called")
-	return nil
+func (self *tokenSingleChar) compile(c *compiler, greedy bool) {
+	separators := c.separators
+	c.consume(func(haystack string, pos int, r rune, w int) bool {
+		if !self.matchSeparators && separators.contains(r) {
+			return false
+		}
+		return !(self.protectDotfiles && isHiddenAt(haystack, pos, separators))
+	})
 }
 
-func (self *tokenSingleChar) Matches(haystack string, start int, directorySeparator rune) (bool, string) {
-	if len(haystack) < start+1 {
-		return false, ""
-	}
+// ============================================================================
+// Match any one character within a set of runes: [abc0-9_] or [^a-z]
+// ============================================================================
 
-	return true, haystack[start : start+1]
+// An inclusive range of runes. A single member of a set has from == to.
+type charRange struct {
+	from rune
+	to   rune
 }
 
-// ============================================================================
-// Match any one character within a range of runes
-// ============================================================================
-type tokenRange struct {
-	from     rune
-	to       rune
-	inverted bool
+type tokenCharSet struct {
+	tokenSpan
+	ranges          []charRange
+	classes         []charClass
+	inverted        bool
+	foldCase        bool
+	protectDotfiles bool
+	matchSeparators bool
 }
 
-func (self *tokenRange) Type() tokenType {
-	return kTokenRange
+func (self *tokenCharSet) Type() tokenType {
+	return kTokenCharSet
 }
 
-func (self *tokenRange) String() string {
+func (self *tokenCharSet) String() string {
+	text := "["
 	if self.inverted {
-		return fmt.Sprintf("[^%s-%s]", self.from, self.to)
-	} else {
-		return fmt.Sprintf("[%s-%s]", self.from, self.to)
+		text += "^"
+	}
+	for _, charRange := range self.ranges {
+		if charRange.from == charRange.to {
+			text += string(charRange.from)
+		} else {
+			text += string(charRange.from) + "-" + string(charRange.to)
+		}
 	}
+	for _, class := range self.classes {
+		text += "[:" + class.name + ":]"
+	}
+	return text + "]"
 }
 
-func (self *tokenRange) IsWildcard() bool {
+func (self *tokenCharSet) IsWildcard() bool {
 	return true
 }
 
-func (self *tokenRange) CanHaveMultipleAnswers() bool {
+// Is the rune a member of the set? This ignores the inverted flag.
+func (self *tokenCharSet) contains(r rune) bool {
+	for _, charRange := range self.ranges {
+		if r >= charRange.from && r <= charRange.to {
+			return true
+		}
+	}
+	for _, class := range self.classes {
+		if unicode.IsOneOf(class.tables, r) {
+			return true
+		}
+	}
 	return false
 }
 
-func (self *tokenRange) CanMatchZeroCharacters() bool {
+// Is the rune, or when folding case, any case of the rune, a member of the set?
+func (self *tokenCharSet) containsFolded(r rune) bool {
+	if self.contains(r) {
+		return true
+	}
+	if self.foldCase {
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if self.contains(f) {
+				return true
+			}
+		}
+	}
 	return false
 }
 
-func (self *tokenRange) AllMatchedPatterns(ctx context.Context, haystack string, start int, directorySeparator rune) chan string {
-	panic("should not be called")
-	return nil
-}
-
-func (self *tokenRange) Matches(haystack string, start int, directorySeparator rune) (bool, string) {
-	if len(haystack) < start+1 {
-		return false, ""
-	}
-
-	//r, w := utf8.DecodeRuneInString(l.input[l.pos:])
-	r, _ := utf8.DecodeRuneInString(haystack[start:])
-
-	if self.inverted {
-		if r >= self.from && r <= self.to {
-			return false, ""
-		} else {
-			return true, string(r)
+func (self *tokenCharSet) compile(c *compiler, greedy bool) {
+	separators := c.separators
+	c.consume(func(haystack string, pos int, r rune, w int) bool {
+		if !self.matchSeparators && separators.contains(r) {
+			return false
 		}
-	} else {
-		if r >= self.from && r <= self.to {
-			return true, string(r)
-		} else {
-			return false, ""
+		if self.protectDotfiles && isHiddenAt(haystack, pos, separators) {
+			return false
 		}
-	}
+		return self.containsFolded(r) != self.inverted
+	})
 }
 
 // ============================================================================
 // Match multiple characters up to a single directory level
 // ============================================================================
 
-type tokenMultiCharSingleDirectory struct{}
+type tokenMultiCharSingleDirectory struct {
+	tokenSpan
+	protectDotfiles bool
+}
 
 func (self *tokenMultiCharSingleDirectory) Type() tokenType {
 	return kTokenMultiCharSingleDirectory
@@ -189,173 +234,255 @@ func (self *tokenMultiCharSingleDirectory) IsWildcard() bool {
 	return true
 }
 
-func (self *tokenMultiCharSingleDirectory) CanHaveMultipleAnswers() bool {
-	return true
+// The directory separator character is our delimiter. Only "" can be
+// matched before a hidden name.
+func (self *tokenMultiCharSingleDirectory) compile(c *compiler, greedy bool) {
+	separators := c.separators
+	c.loop(greedy, func() {
+		c.consume(func(haystack string, pos int, r rune, w int) bool {
+			if self.protectDotfiles && isHiddenAt(haystack, pos, separators) {
+				return false
+			}
+			return !separators.contains(r)
+		})
+	})
 }
 
-func (self *tokenMultiCharSingleDirectory) CanMatchZeroCharacters() bool {
-	return true
-}
+// ============================================================================
+// Match multiple characters up to a any directory level
+// ============================================================================
 
-func (self *tokenMultiCharSingleDirectory) AllMatchedPatterns(ctx context.Context, haystack string, start int, directorySeparator rune) chan string {
-	resultChan := make(chan string)
+type tokenMultiCharMultiDirectory struct {
+	tokenSpan
+	directoriesOnly bool
+	protectDotfiles bool
+}
 
-	go func() {
-		defer close(resultChan)
+func (self *tokenMultiCharMultiDirectory) Type() tokenType {
+	return kTokenMultiCharMultiDirectory
+}
 
-		var result string
+func (self *tokenMultiCharMultiDirectory) String() string {
+	return "**"
+}
 
-		if len(haystack) == start {
-			resultChan <- ""
-			return
-		} else if len(haystack) < start {
-			return
-		}
+func (self *tokenMultiCharMultiDirectory) IsWildcard() bool {
+	return true
+}
 
-		r, _ := utf8.DecodeRuneInString(haystack[start:])
-		// The directory separator character is our delimiter
-		if r == directorySeparator {
-			return
-		}
+// Any characters at all, including directory separators. Hidden names,
+// and anything in them, can't be matched. When only directories match,
+// each one ends at a separator, so the match must be followed by one.
+func (self *tokenMultiCharMultiDirectory) compile(c *compiler, greedy bool) {
+	separators := c.separators
+	c.loop(greedy, func() {
+		c.consume(func(haystack string, pos int, r rune, w int) bool {
+			return !(self.protectDotfiles && isHiddenAt(haystack, pos, separators))
+		})
+	})
 
-		var pos int
-		for pos = start; ; {
+	if self.directoriesOnly {
+		c.assert(-1, func(haystack string, start int, pos int) bool {
 			r, w := utf8.DecodeRuneInString(haystack[pos:])
-			if w == 0 || r == directorySeparator {
-				break
-			}
-			pos += w
-			result += string(r)
-			resultChan <- result
-		}
-	}()
-
-	return resultChan
+			return w > 0 && separators.contains(r)
+		})
+	}
 }
 
-func (self *tokenMultiCharSingleDirectory) Matches(haystack string, start int, directorySeparator rune) (bool, string) {
-	if len(haystack) == start {
-		return true, ""
+// ============================================================================
+// Match one of several alternative sequences of tokens: {a,b,c}
+// ============================================================================
 
-	} else if len(haystack) < start {
-		return false, ""
-	}
+type tokenAlternation struct {
+	tokenSpan
+	// For a named wildcard, {name:pattern}, which has one alternative
+	name         string
+	alternatives [][]tokenInterface
+}
 
-	r, _ := utf8.DecodeRuneInString(haystack[start:])
-	// The directory separator character is our delimiter
-	if r == directorySeparator {
-		return true, ""
-	}
+func (self *tokenAlternation) Type() tokenType {
+	return kTokenAlternation
+}
 
-	var pos int
-	for pos = start; ; {
-		r, w := utf8.DecodeRuneInString(haystack[pos:])
-		if w == 0 || r == directorySeparator {
-			break
-		}
-		pos += w
+func (self *tokenAlternation) String() string {
+	if self.name != "" {
+		return "{" + self.name + ":" + alternativesString(self.alternatives, ",") + "}"
 	}
+	return "{" + alternativesString(self.alternatives, ",") + "}"
+}
 
-	return true, haystack[start:pos]
+func (self *tokenAlternation) IsWildcard() bool {
+	return true
+}
+
+func (self *tokenAlternation) compile(c *compiler, greedy bool) {
+	c.alternatives(self.alternatives, greedy)
 }
 
 // ============================================================================
-// Match multiple characters up to a any directory level
+// Match a decimal integer within a range: {1..20} or zero-padded {001..120}
 // ============================================================================
 
-type tokenMultiCharMultiDirectory struct {
-	directoriesOnly bool
+type tokenNumericRange struct {
+	tokenSpan
+	from int64
+	to   int64
+	// When non-zero, the number of digits that a number must have
+	width int
 }
 
-func (self *tokenMultiCharMultiDirectory) Type() tokenType {
-	return kTokenMultiCharMultiDirectory
+func (self *tokenNumericRange) Type() tokenType {
+	return kTokenNumericRange
 }
 
-func (self *tokenMultiCharMultiDirectory) String() string {
-	return "**"
+func (self *tokenNumericRange) String() string {
+	return fmt.Sprintf("{%0*d..%0*d}", self.width, self.from, self.width, self.to)
 }
 
-func (self *tokenMultiCharMultiDirectory) IsWildcard() bool {
+func (self *tokenNumericRange) IsWildcard() bool {
 	return true
 }
 
-func (self *tokenMultiCharMultiDirectory) CanHaveMultipleAnswers() bool {
-	return true
-}
+// The digits are checked against the range once they have all been seen
+func (self *tokenNumericRange) compile(c *compiler, greedy bool) {
+	isDigit := func(haystack string, pos int, r rune, w int) bool {
+		return r >= '0' && r <= '9'
+	}
 
-func (self *tokenMultiCharMultiDirectory) CanMatchZeroCharacters() bool {
-	return false
-}
+	c.group(func() {
+		c.optional(true, func() {
+			c.consume(func(haystack string, pos int, r rune, w int) bool {
+				return r == '-'
+			})
+		})
+		c.consume(isDigit)
+		c.loop(greedy, func() {
+			c.consume(isDigit)
+		})
+	}, func(haystack string, start int, pos int) bool {
+		number := haystack[start:pos]
+		return self.inRange(number, strings.TrimPrefix(number, "-"))
+	})
+}
+
+func (self *tokenNumericRange) inRange(number string, digits string) bool {
+	if self.width > 0 {
+		if len(digits) != self.width {
+			return false
+		}
+	} else if len(digits) > 1 && digits[0] == '0' {
+		// Without padding, leading zeros are not allowed
+		return false
+	} else if number == "-0" {
+		return false
+	}
 
-func (self *tokenMultiCharMultiDirectory) AllMatchedPatterns(ctx context.Context, haystack string, start int, directorySeparator rune) chan string {
-	resultChan := make(chan string)
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return false
+	}
+	return n >= self.from && n <= self.to
+}
 
-	go func() {
-		defer close(resultChan)
+// ============================================================================
+// Match an extended glob operator: ?(a|b), *(a|b), +(a|b), @(a|b), !(a|b)
+// ============================================================================
 
-		if self.directoriesOnly {
-			parts := strings.Split(haystack[start:], string(directorySeparator))
+type tokenExtGlob struct {
+	tokenSpan
+	// One of '?', '*', '+', '@', or '!'
+	operator        rune
+	alternatives    [][]tokenInterface
+	protectDotfiles bool
+}
 
-			// We need to discard the last item in the Split(), because it won't be
-			// a directory (it will be a file). Even if haystack ends with directorySeparator,
-			// the last string in the Split() will be "", which also needs to be discarded.
-			// But, if there was no directorySeparator at all, then there's only one part.
-			if len(parts) > 1 {
-				parts = parts[0 : len(parts)-1]
-			}
+func (self *tokenExtGlob) Type() tokenType {
+	return kTokenExtGlob
+}
 
-			var result string
-			var prevResult string
-
-			for _, part := range parts {
-				if prevResult == "" {
-					result = part
-				} else {
-					result = prevResult + string(directorySeparator) + part
-				}
-				resultChan <- result
-				prevResult = result
-
-				// Canceled?
-				select {
-				case <-ctx.Done():
-					return
-				default:
-					// no-op
-				}
-			}
-		} else {
-			panic("Not yet implemented")
-		}
-	}()
+func (self *tokenExtGlob) String() string {
+	return string(self.operator) + "(" + alternativesString(self.alternatives, "|") + ")"
+}
 
-	return resultChan
+func (self *tokenExtGlob) IsWildcard() bool {
+	return true
 }
 
-func (self *tokenMultiCharMultiDirectory) Matches(haystack string, start int, directorySeparator rune) (bool, string) {
-	if len(haystack) == start {
-		return true, ""
+func (self *tokenExtGlob) compile(c *compiler, greedy bool) {
+	switch self.operator {
+	case '@':
+		c.alternatives(self.alternatives, greedy)
+
+	case '?':
+		c.optional(greedy, func() {
+			c.alternatives(self.alternatives, greedy)
+		})
+
+	case '*':
+		c.loop(greedy, func() {
+			c.alternatives(self.alternatives, greedy)
+		})
+
+	case '+':
+		c.repeat(greedy, func() {
+			c.alternatives(self.alternatives, greedy)
+		})
+
+	case '!':
+		// Like '*', this only goes up to the directory separator, and only
+		// matches "" before a hidden name. The text must not be matched by
+		// any of the alternatives.
+		excluded := c.subprogram(self.alternatives)
+		separators := c.separators
+		c.group(func() {
+			c.loop(greedy, func() {
+				c.consume(func(haystack string, pos int, r rune, w int) bool {
+					if self.protectDotfiles && isHiddenAt(haystack, pos, separators) {
+						return false
+					}
+					return !separators.contains(r)
+				})
+			})
+		}, func(haystack string, start int, pos int) bool {
+			_, matched := excluded.run(haystack, start, pos, true)
+			return !matched
+		})
+	}
+}
 
-	} else if len(haystack) < start {
-		return false, ""
+// Does a hidden file or directory name, which starts with '.', start
+// at the position?
+func isHiddenAt(haystack string, pos int, separators separatorSet) bool {
+	if pos >= len(haystack) || haystack[pos] != '.' {
+		return false
+	}
+	if pos == 0 {
+		return true
 	}
+	r, _ := utf8.DecodeLastRuneInString(haystack[:pos])
+	return separators.contains(r)
+}
 
-	//r, _ := utf8.DecodeRuneInString(haystack[start:])
-	/*
-		// The directory separator character is our delimiter
-		if r == directorySeparator {
-			return true, ""
-		}*/
-
-	var pos int
-	for pos = start; ; {
-		//r, w := utf8.DecodeRuneInString(haystack[pos:])
-		_, w := utf8.DecodeRuneInString(haystack[pos:])
-		if w == 0 /*|| r == directorySeparator */ {
-			break
+// Are the runes equal under Unicode simple case folding?
+func equalFoldRune(a rune, b rune) bool {
+	if a == b {
+		return true
+	}
+	for f := unicode.SimpleFold(a); f != a; f = unicode.SimpleFold(f) {
+		if f == b {
+			return true
 		}
-		pos += w
 	}
+	return false
+}
 
-	return true, haystack[start:pos]
+// Renders lists of tokens, with a separator between the lists
+func alternativesString(alternatives [][]tokenInterface, separator string) string {
+	texts := make([]string, len(alternatives))
+	for i, tokens := range alternatives {
+		for _, token := range tokens {
+			texts[i] += token.String()
+		}
+	}
+	return strings.Join(texts, separator)
 }
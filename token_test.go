@@ -1,193 +1,214 @@
 package globingo
 
 import (
-	"context"
+	"unicode/utf8"
+
 	. "gopkg.in/check.v1"
 )
 
+// Returns every string which the token, compiled on its own, can match at
+// the start position, shortest first.
+func tokenMatches(token tokenInterface, haystack string, start int, separators separatorSet) []string {
+	program := compileTokens([]tokenInterface{token}, separators)
+
+	var patterns []string
+	for end := start; ; {
+		if _, matched := program.run(haystack, start, end, true); matched {
+			patterns = append(patterns, haystack[start:end])
+		}
+		_, w := utf8.DecodeRuneInString(haystack[end:])
+		if w == 0 {
+			break
+		}
+		end += w
+	}
+	return patterns
+}
+
 func (s *MySuite) TestTokenPlainText(c *C) {
 
 	token := &tokenPlainText{
 		text: "foo",
 	}
 
-	var m bool
-	var t string
+	var patterns []string
 
 	// exactly the same
-	m, t = token.Matches("foo", 0, '/')
-	c.Check(m, Equals, true)
-	c.Check(t, Equals, "foo")
+	patterns = tokenMatches(token, "foo", 0, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"foo"})
 
 	// not enough characters to match
-	m, t = token.Matches("fo", 0, '/')
-	c.Check(m, Equals, false)
+	patterns = tokenMatches(token, "fo", 0, separatorSet{'/'})
+	c.Check(patterns, HasLen, 0)
 
 	// substring at pos 0
-	m, t = token.Matches("foodle", 0, '/')
-	c.Check(m, Equals, true)
-	c.Check(t, Equals, "foo")
+	patterns = tokenMatches(token, "foodle", 0, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"foo"})
 
 	// substring in the middle
-	m, t = token.Matches("trefooblah", 0, '/')
-	c.Check(m, Equals, false)
+	patterns = tokenMatches(token, "trefooblah", 0, separatorSet{'/'})
+	c.Check(patterns, HasLen, 0)
 
-	m, t = token.Matches("trefooblah", 3, '/')
-	c.Check(m, Equals, true)
-	c.Check(t, Equals, "foo")
+	patterns = tokenMatches(token, "trefooblah", 3, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"foo"})
 
 	// substring at the end
-	m, t = token.Matches("trefoo", 0, '/')
-	c.Check(m, Equals, false)
+	patterns = tokenMatches(token, "trefoo", 0, separatorSet{'/'})
+	c.Check(patterns, HasLen, 0)
 
-	m, t = token.Matches("trefoo", 3, '/')
-	c.Check(m, Equals, true)
-	c.Check(t, Equals, "foo")
+	patterns = tokenMatches(token, "trefoo", 3, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"foo"})
 }
 
 func (s *MySuite) TestTokenSingleChar(c *C) {
 
 	token := &tokenSingleChar{}
 
-	var m bool
-	var t string
+	var patterns []string
 
 	// first character
-	m, t = token.Matches("bar", 0, '/')
-	c.Check(m, Equals, true)
-	c.Check(t, Equals, "b")
+	patterns = tokenMatches(token, "bar", 0, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"b"})
 
 	// middle character
-	m, t = token.Matches("bar", 1, '/')
-	c.Check(m, Equals, true)
-	c.Check(t, Equals, "a")
+	patterns = tokenMatches(token, "bar", 1, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"a"})
 
 	// last character
-	m, t = token.Matches("bar", 2, '/')
-	c.Check(m, Equals, true)
-	c.Check(t, Equals, "r")
+	patterns = tokenMatches(token, "bar", 2, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"r"})
 
 	// empty string
-	m, t = token.Matches("", 0, '/')
-	c.Check(m, Equals, false)
+	patterns = tokenMatches(token, "", 0, separatorSet{'/'})
+	c.Check(patterns, HasLen, 0)
 }
 
-func (s *MySuite) TestTokenRangeNormal(c *C) {
-	token := &tokenRange{
-		from: 'A',
-		to:   'C',
+func (s *MySuite) TestTokenCharSetRange(c *C) {
+	token := &tokenCharSet{
+		ranges: []charRange{{from: 'A', to: 'C'}},
 	}
 
-	var m bool
-	var t string
+	var patterns []string
 
 	// first character
-	m, t = token.Matches("bar", 0, '/')
-	c.Check(m, Equals, false)
+	patterns = tokenMatches(token, "bar", 0, separatorSet{'/'})
+	c.Check(patterns, HasLen, 0)
 
-	m, t = token.Matches("Bar", 0, '/')
-	c.Check(m, Equals, true)
-	c.Check(t, Equals, "B")
+	patterns = tokenMatches(token, "Bar", 0, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"B"})
 
 	// middle character
-	m, t = token.Matches("bar", 1, '/')
-	c.Check(m, Equals, false)
+	patterns = tokenMatches(token, "bar", 1, separatorSet{'/'})
+	c.Check(patterns, HasLen, 0)
 
-	m, t = token.Matches("bAr", 1, '/')
-	c.Check(m, Equals, true)
-	c.Check(t, Equals, "A")
+	patterns = tokenMatches(token, "bAr", 1, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"A"})
 
 	// last character
-	m, t = token.Matches("bac", 2, '/')
-	c.Check(m, Equals, false)
+	patterns = tokenMatches(token, "bac", 2, separatorSet{'/'})
+	c.Check(patterns, HasLen, 0)
 
-	m, t = token.Matches("baC", 2, '/')
-	c.Check(m, Equals, true)
-	c.Check(t, Equals, "C")
+	patterns = tokenMatches(token, "baC", 2, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"C"})
 
 	// empty string
-	m, t = token.Matches("", 0, '/')
-	c.Check(m, Equals, false)
+	patterns = tokenMatches(token, "", 0, separatorSet{'/'})
+	c.Check(patterns, HasLen, 0)
 }
 
-func (s *MySuite) TestTokenRangeInverted(c *C) {
-	token := &tokenRange{
-		from:     'A',
-		to:       'C',
+func (s *MySuite) TestTokenCharSetInverted(c *C) {
+	token := &tokenCharSet{
+		ranges:   []charRange{{from: 'A', to: 'C'}},
 		inverted: true,
 	}
 
-	var m bool
-	var t string
+	var patterns []string
 
 	// first character
-	m, t = token.Matches("bar", 0, '/')
-	c.Check(m, Equals, true)
-	c.Check(t, Equals, "b")
+	patterns = tokenMatches(token, "bar", 0, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"b"})
 
-	m, t = token.Matches("Bar", 0, '/')
-	c.Check(m, Equals, false)
+	patterns = tokenMatches(token, "Bar", 0, separatorSet{'/'})
+	c.Check(patterns, HasLen, 0)
 
 	// middle character
-	m, t = token.Matches("bar", 1, '/')
-	c.Check(m, Equals, true)
-	c.Check(t, Equals, "a")
+	patterns = tokenMatches(token, "bar", 1, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"a"})
 
-	m, t = token.Matches("bAr", 1, '/')
-	c.Check(m, Equals, false)
+	patterns = tokenMatches(token, "bAr", 1, separatorSet{'/'})
+	c.Check(patterns, HasLen, 0)
 
 	// last character
-	m, t = token.Matches("bac", 2, '/')
-	c.Check(m, Equals, true)
-	c.Check(t, Equals, "c")
+	patterns = tokenMatches(token, "bac", 2, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"c"})
 
-	m, t = token.Matches("baC", 2, '/')
-	c.Check(m, Equals, false)
+	patterns = tokenMatches(token, "baC", 2, separatorSet{'/'})
+	c.Check(patterns, HasLen, 0)
 
 	// empty string
-	m, t = token.Matches("", 0, '/')
-	c.Check(m, Equals, false)
+	patterns = tokenMatches(token, "", 0, separatorSet{'/'})
+	c.Check(patterns, HasLen, 0)
 }
 
-func (s *MySuite) TestTokenSingleDirAllMatchedPatterns1(c *C) {
+func (s *MySuite) TestTokenCharSetMultiple(c *C) {
+	token := &tokenCharSet{
+		ranges: []charRange{{from: 'a', to: 'c'}, {from: '0', to: '9'}, {from: '_', to: '_'}},
+	}
+
+	var patterns []string
+
+	for _, haystack := range []string{"a", "b", "c", "0", "5", "9", "_"} {
+		patterns = tokenMatches(token, haystack, 0, separatorSet{'/'})
+		c.Check(patterns, DeepEquals, []string{haystack})
+	}
+
+	for _, haystack := range []string{"d", "A", "-", "/", ""} {
+		patterns = tokenMatches(token, haystack, 0, separatorSet{'/'})
+		c.Check(patterns, HasLen, 0)
+	}
+
+	// multi-byte runes are matched whole
+	token = &tokenCharSet{
+		ranges:   []charRange{{from: 'a', to: 'z'}},
+		inverted: true,
+	}
+	patterns = tokenMatches(token, "日本", 0, separatorSet{'/'})
+	c.Check(patterns, DeepEquals, []string{"日"})
+}
+
+func (s *MySuite) TestTokenSingleDirMatches1(c *C) {
 	// We pretend the glob is *.c
 	token := &tokenMultiCharSingleDirectory{}
 
 	haystack := "foo.c"
 
-	ctx, _ := context.WithCancel(context.Background())
-	var patterns []string
-	for pattern := range token.AllMatchedPatterns(ctx, haystack, 0, '/') {
-		patterns = append(patterns, pattern)
-	}
+	patterns := tokenMatches(token, haystack, 0, separatorSet{'/'})
 
-	c.Assert(len(patterns), Equals, 5)
-	c.Check(patterns[0], Equals, "f")
-	c.Check(patterns[1], Equals, "fo")
-	c.Check(patterns[2], Equals, "foo")
-	c.Check(patterns[3], Equals, "foo.")
-	c.Check(patterns[4], Equals, "foo.c")
+	c.Assert(len(patterns), Equals, 6)
+	c.Check(patterns[0], Equals, "")
+	c.Check(patterns[1], Equals, "f")
+	c.Check(patterns[2], Equals, "fo")
+	c.Check(patterns[3], Equals, "foo")
+	c.Check(patterns[4], Equals, "foo.")
+	c.Check(patterns[5], Equals, "foo.c")
 }
 
-func (s *MySuite) TestTokenSingleDirAllMatchedPatterns2(c *C) {
+func (s *MySuite) TestTokenSingleDirMatches2(c *C) {
 	// We pretend the glob is */foo.c
 	token := &tokenMultiCharSingleDirectory{}
 
 	haystack := "bar/foo.c"
 
-	ctx, _ := context.WithCancel(context.Background())
-	var patterns []string
-	for pattern := range token.AllMatchedPatterns(ctx, haystack, 0, '/') {
-		patterns = append(patterns, pattern)
-	}
+	patterns := tokenMatches(token, haystack, 0, separatorSet{'/'})
 
-	c.Assert(len(patterns), Equals, 3)
-	c.Check(patterns[0], Equals, "b")
-	c.Check(patterns[1], Equals, "ba")
-	c.Check(patterns[2], Equals, "bar")
+	c.Assert(len(patterns), Equals, 4)
+	c.Check(patterns[0], Equals, "")
+	c.Check(patterns[1], Equals, "b")
+	c.Check(patterns[2], Equals, "ba")
+	c.Check(patterns[3], Equals, "bar")
 }
 
-func (s *MySuite) TestTokenMultiDirAllMatchedPatterns1(c *C) {
+func (s *MySuite) TestTokenMultiDirMatches1(c *C) {
 	// We pretend the glob is a/**/foo.c
 	token := &tokenMultiCharMultiDirectory{
 		directoriesOnly: true,
@@ -195,11 +216,7 @@ func (s *MySuite) TestTokenMultiDirAllMatchedPatterns1(c *C) {
 
 	haystack := "a/bb/ccc/ddd/eee/foo.c"
 
-	ctx, _ := context.WithCancel(context.Background())
-	var patterns []string
-	for pattern := range token.AllMatchedPatterns(ctx, haystack, 2, '/') {
-		patterns = append(patterns, pattern)
-	}
+	patterns := tokenMatches(token, haystack, 2, separatorSet{'/'})
 
 	c.Assert(len(patterns), Equals, 4)
 	c.Check(patterns[0], Equals, "bb")
@@ -208,7 +225,7 @@ func (s *MySuite) TestTokenMultiDirAllMatchedPatterns1(c *C) {
 	c.Check(patterns[3], Equals, "bb/ccc/ddd/eee")
 }
 
-func (s *MySuite) TestTokenMultiDirAllMatchedPatterns2(c *C) {
+func (s *MySuite) TestTokenMultiDirMatches2(c *C) {
 	// We pretend the glob is a/**/foo.c
 	token := &tokenMultiCharMultiDirectory{
 		directoriesOnly: true,
@@ -216,11 +233,7 @@ func (s *MySuite) TestTokenMultiDirAllMatchedPatterns2(c *C) {
 
 	haystack := "a/bb/ccc/ddd/eee/"
 
-	ctx, _ := context.WithCancel(context.Background())
-	var patterns []string
-	for pattern := range token.AllMatchedPatterns(ctx, haystack, 2, '/') {
-		patterns = append(patterns, pattern)
-	}
+	patterns := tokenMatches(token, haystack, 2, separatorSet{'/'})
 
 	c.Assert(len(patterns), Equals, 4)
 	c.Check(patterns[0], Equals, "bb")
@@ -228,3 +241,34 @@ func (s *MySuite) TestTokenMultiDirAllMatchedPatterns2(c *C) {
 	c.Check(patterns[2], Equals, "bb/ccc/ddd")
 	c.Check(patterns[3], Equals, "bb/ccc/ddd/eee")
 }
+
+func (s *MySuite) TestTokenMultiDirMatches3(c *C) {
+	// We pretend the glob is a/**
+	token := &tokenMultiCharMultiDirectory{}
+
+	haystack := "a/b/c"
+
+	patterns := tokenMatches(token, haystack, 2, separatorSet{'/'})
+
+	// When not followed by a separator, '**' can match "", like '*'
+	c.Assert(len(patterns), Equals, 4)
+	c.Check(patterns[0], Equals, "")
+	c.Check(patterns[1], Equals, "b")
+	c.Check(patterns[2], Equals, "b/")
+	c.Check(patterns[3], Equals, "b/c")
+}
+
+func (s *MySuite) TestTokenMultiDirMatchesSeparators(c *C) {
+	// We pretend the glob is a/**/foo.c, with two separators
+	token := &tokenMultiCharMultiDirectory{
+		directoriesOnly: true,
+	}
+
+	haystack := `a/bb\ccc/foo.c`
+
+	patterns := tokenMatches(token, haystack, 2, separatorSet{'/', '\\'})
+
+	c.Assert(len(patterns), Equals, 2)
+	c.Check(patterns[0], Equals, "bb")
+	c.Check(patterns[1], Equals, `bb\ccc`)
+}
@@ -0,0 +1,119 @@
+package globingo
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Windows paths can start with a volume:
+//
+//	C:                      a drive letter
+//	\\server\share          a UNC share
+//	\\?\C: or \\.\C:        a drive, with a long path or device prefix
+//	\\?\UNC\server\share    a UNC share, with a long path prefix
+//	\\.\PhysicalDrive0      a device, or any other name after a prefix
+//
+// Either '\' or '/' can be used as the separator.
+
+func isWindowsSeparator(b byte) bool {
+	return b == '\\' || b == '/'
+}
+
+// Returns the length of the first segment of the path
+func segmentLength(path string) int {
+	for i := 0; i < len(path); i++ {
+		if isWindowsSeparator(path[i]) {
+			return i
+		}
+	}
+	return len(path)
+}
+
+func isDriveLetter(path string) bool {
+	return len(path) >= 2 && path[1] == ':' &&
+		((path[0] >= 'a' && path[0] <= 'z') || (path[0] >= 'A' && path[0] <= 'Z'))
+}
+
+// Returns the length of a "server\share" at the start of the path, or 0
+func uncLength(path string) int {
+	server := segmentLength(path)
+	if server == 0 || server == len(path) {
+		return 0
+	}
+	share := segmentLength(path[server+1:])
+	if share == 0 {
+		return 0
+	}
+	return server + 1 + share
+}
+
+// Splits the volume from the start of a Windows path. Returns the volume in
+// a canonical form, so that volumes which name the same place are equal:
+// the separators are '\', letters are upper case, and the long path prefix
+// is removed from drives and UNC shares. Returns "" and 0 when the path
+// doesn't start with a volume.
+func splitWindowsVolume(path string) (volume string, length int) {
+	if len(path) < 2 || !isWindowsSeparator(path[0]) || !isWindowsSeparator(path[1]) {
+		if isDriveLetter(path) {
+			return strings.ToUpper(path[:2]), 2
+		}
+		return "", 0
+	}
+
+	// A long path or device prefix
+	if len(path) >= 4 && (path[2] == '?' || path[2] == '.') && isWindowsSeparator(path[3]) {
+		rest := path[4:]
+		if isDriveLetter(rest) && segmentLength(rest) == 2 {
+			return strings.ToUpper(rest[:2]), 4 + 2
+		}
+		if len(rest) > 4 && strings.EqualFold(rest[:3], "UNC") && isWindowsSeparator(rest[3]) {
+			if n := uncLength(rest[4:]); n > 0 {
+				return uncVolume(rest[4 : 4+n]), 4 + 4 + n
+			}
+		}
+		if n := segmentLength(rest); n > 0 {
+			return `\\` + path[2:3] + `\` + strings.ToUpper(rest[:n]), 4 + n
+		}
+		return "", 0
+	}
+
+	if n := uncLength(path[2:]); n > 0 {
+		return uncVolume(path[2 : 2+n]), 2 + n
+	}
+	return "", 0
+}
+
+func uncVolume(serverShare string) string {
+	server := segmentLength(serverShare)
+	return `\\` + strings.ToUpper(serverShare[:server]) + `\` + strings.ToUpper(serverShare[server+1:])
+}
+
+// A volume found in a text
+type volumeSpan struct {
+	volume string
+	start  int
+	end    int
+}
+
+// Finds the volumes in a text which has Windows paths in it. A volume is
+// only found at the start of the text, or after a separator or another
+// rune which can't be part of a name, so the C: in XC:\ isn't one.
+func findWindowsVolumes(text string) []volumeSpan {
+	var volumes []volumeSpan
+	afterName := false
+	for pos := 0; pos < len(text); {
+		if !afterName {
+			if volume, length := splitWindowsVolume(text[pos:]); length > 0 {
+				volumes = append(volumes, volumeSpan{volume: volume, start: pos, end: pos + length})
+				pos += length
+				afterName = true
+				continue
+			}
+		}
+		r, w := utf8.DecodeRuneInString(text[pos:])
+		afterName = unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+		pos += w
+	}
+	return volumes
+}